@@ -3,10 +3,14 @@ package amqp
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"openhvx-agent/redact"
+	"openhvx-agent/taskstore"
+
 	amqp091 "github.com/rabbitmq/amqp091-go"
 )
 
@@ -25,28 +29,111 @@ type Task struct {
 	CorrelationID string                 `json:"correlationId,omitempty"`
 	Attempt       int                    `json:"attempt,omitempty"`
 	MaxAttempts   int                    `json:"maxAttempts,omitempty"`
+	NotBefore     time.Time              `json:"notBefore,omitzero"` // RFC3339: retenue localement jusqu'à cette date
+	ExpiresAt     time.Time              `json:"expiresAt,omitzero"` // RFC3339: au-delà, résultat EXPIRED sans exécution
+	DryRun        bool                   `json:"dryRun,omitempty"`   // plan seulement: __ctx.dryRun, aucune modification
+}
+
+// ErrTaskTimeout doit être enveloppée par le handler quand l'action dépasse son délai:
+// le résultat publié porte alors "timedOut": true pour distinguer "lent" de "échoué".
+var ErrTaskTimeout = errors.New("task timed out")
+
+// ErrTaskCancelled doit être enveloppée par le handler quand la tâche a été annulée
+// (task.cancel): résultat publié avec ok=false et status "cancelled", jamais rejouée.
+var ErrTaskCancelled = errors.New("task cancelled")
+
+// CancelAction est l'action de contrôle annulant une tâche en vol (data.taskId).
+// Elle est traitée hors du pool de workers, depuis la queue des tâches ou la queue de contrôle.
+const CancelAction = "task.cancel"
+
+// ErrTaskInterrupted: la tâche avait démarré dans un process agent précédent qui s'est
+// arrêté avant la fin; elle n'est pas ré-exécutée implicitement (état VM inconnu).
+var ErrTaskInterrupted = errors.New("task interrupted by agent restart; not re-executed")
+
+// attemptNo renvoie le numéro d'essai (1 si absent).
+func (t Task) attemptNo() int {
+	if t.Attempt < 1 {
+		return 1
+	}
+	return t.Attempt
 }
 
 type HandlerFunc func(Task) (any, error)
 
+// TaskLock est un verrou réservé à l'arrivée d'une tâche (ordre FIFO),
+// attendu avant l'exécution puis libéré une fois le résultat connu.
+type TaskLock interface {
+	Wait() time.Duration
+	Release()
+}
+
+type ConsumerOpts struct {
+	AgentID     string
+	Handle      HandlerFunc
+	Concurrency int                              // tâches exécutées en parallèle (défaut 4)
+	ClassLimits map[string]int                   // plafond par classe d'action, ex: {"bulk":1}
+	ClassOf     func(action string) string       // classe d'une action (nil = pas de plafond par classe)
+	Lanes       []string                         // classes ayant leur propre queue agent.<id>.tasks.<classe>
+	LaneSlots   map[string]int                   // workers réservés par classe, ex: {"interactive":1}
+	Prefetch    int                              // messages non-ack en vol (défaut 2 x Concurrency)
+	ReserveLock func(Task) TaskLock              // sérialisation optionnelle (ex: par VM); nil = aucune
+	RetryBase   time.Duration                    // délai avant le 2e essai (défaut 5s), doublé à chaque essai
+	RetryMax    time.Duration                    // plafond du délai entre essais (défaut 5min)
+	Store       *taskstore.Store                 // dédup par taskId (nil = désactivé)
+	Cancel      func(taskID, reason string) bool // annulation d'une tâche en vol (task.cancel)
+	OnEvent     func(TaskEvent)                  // étapes received / started / finished (audit); nil = rien
+	ScheduleDir string                           // persistance des tâches notBefore (vide = en mémoire)
+
+	sched *scheduler
+}
+
 func StartTaskConsumer(agentID string, handle HandlerFunc) error {
-	if agentID == "" {
+	return StartTaskConsumerWithOpts(ConsumerOpts{AgentID: agentID, Handle: handle})
+}
+
+func StartTaskConsumerWithOpts(opts ConsumerOpts) error {
+	if opts.AgentID == "" {
 		return fmt.Errorf("agentID is required")
 	}
-	if handle == nil {
+	if opts.Handle == nil {
 		return fmt.Errorf("task handler is required")
 	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.Prefetch < opts.Concurrency {
+		opts.Prefetch = 2 * opts.Concurrency
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = 5 * time.Second
+	}
+	if opts.RetryMax < opts.RetryBase {
+		opts.RetryMax = 5 * time.Minute
+	}
+	opts.Lanes = validLanes(opts.Lanes)
 
 	if _, err := ensureChannelWithRetry(3, 2*time.Second); err != nil {
 		return fmt.Errorf("AMQP not initialized: %w", err)
 	}
 
-	go consumeLoop(agentID, handle)
+	pool := newWorkerPool(opts)
+	// Scheduler assigné avant de démarrer sa boucle: les tâches restaurées voient opts complet
+	sched := newScheduler(opts.ScheduleDir)
+	opts.sched = sched
+	sched.start(func(d amqp091.Delivery, t Task, preErr error) {
+		dispatch(opts, pool, d, t, preErr)
+	})
+	go consumeLoop(opts, pool)
 	return nil
 }
 
-func consumeLoop(agentID string, handle HandlerFunc) {
-	queueName := fmt.Sprintf("agent.%s.tasks", agentID)
+func consumeLoop(opts ConsumerOpts, pool *workerPool) {
+	agentID := opts.AgentID
+	lanes := taskLanes(opts)
+	queueName := lanes[0].queue
+	deadQueue := queueName + ".dead"
+	controlQueue := fmt.Sprintf("agent.%s.control", agentID)
+	controlKey := agentID + ".control"
 
 	for {
 		c, err := ensureChannelWithRetry(0, 3*time.Second)
@@ -56,37 +143,73 @@ func consumeLoop(agentID string, handle HandlerFunc) {
 			continue
 		}
 
-		// Queue et binding vers l'exchange jobs (rk = agentID)
-		if _, err := c.QueueDeclare(queueName, true, false, false, false, nil); err != nil {
-			log.Printf("[AMQP] declare %s: %v", queueName, err)
+		// Queues des lanes et bindings vers l'exchange jobs (rk = agentID[.lane])
+		if err := declareLanes(c, lanes); err != nil {
+			log.Printf("[AMQP] %v", err)
+			resetConnection()
+			time.Sleep(3 * time.Second)
+			continue
+		}
+
+		// Queue de dead-letter (messages empoisonnés / essais épuisés), conservée 7 jours
+		if _, err := c.QueueDeclare(deadQueue, true, false, false, false, amqp091.Table{
+			"x-message-ttl": int64((7 * 24 * time.Hour).Milliseconds()),
+		}); err != nil {
+			log.Printf("[AMQP] declare %s: %v", deadQueue, err)
 			resetConnection()
 			time.Sleep(3 * time.Second)
 			continue
 		}
-		if err := c.QueueBind(queueName, agentID, JobsEx, false, nil); err != nil {
-			log.Printf("[AMQP] bind %s to %s: %v", queueName, JobsEx, err)
+		if err := c.QueueBind(deadQueue, agentID, DeadLetterEx, false, nil); err != nil {
+			log.Printf("[AMQP] bind %s to %s: %v", deadQueue, DeadLetterEx, err)
 			resetConnection()
 			time.Sleep(3 * time.Second)
 			continue
 		}
 
-		// Limiter les messages non-ack en vol
-		if err := c.Qos(5, 0, false); err != nil {
+		// Queue de contrôle (task.cancel, ...): jamais bloquée derrière les tâches en attente
+		if _, err := c.QueueDeclare(controlQueue, true, false, false, false, nil); err != nil {
+			log.Printf("[AMQP] declare %s: %v", controlQueue, err)
+			resetConnection()
+			time.Sleep(3 * time.Second)
+			continue
+		}
+		if err := c.QueueBind(controlQueue, controlKey, JobsEx, false, nil); err != nil {
+			log.Printf("[AMQP] bind %s to %s: %v", controlQueue, JobsEx, err)
+			resetConnection()
+			time.Sleep(3 * time.Second)
+			continue
+		}
+
+		// Limiter les messages non-ack en vol (au-delà des workers: file d'attente locale)
+		if err := c.Qos(opts.Prefetch, 0, false); err != nil {
 			log.Printf("[AMQP] qos error: %v", err)
 			resetConnection()
 			time.Sleep(3 * time.Second)
 			continue
 		}
 
-		msgs, err := c.Consume(
-			queueName,
-			"agent-"+agentID, // consumer tag
-			false,            // autoAck=false
-			false,            // exclusive
-			false,            // noLocal
-			false,            // noWait
-			nil,
-		)
+		// Un consumer par lane (prefetch propre à chaque consumer)
+		laneMsgs := make([]<-chan amqp091.Delivery, len(lanes))
+		for i, l := range lanes {
+			tag := "agent-" + agentID // consumer tag
+			if l.name != "" {
+				tag += "-" + l.name
+			}
+			laneMsgs[i], err = c.Consume(
+				l.queue,
+				tag,
+				false, // autoAck=false
+				false, // exclusive
+				false, // noLocal
+				false, // noWait
+				nil,
+			)
+			if err != nil {
+				err = fmt.Errorf("consume %s: %w", l.queue, err)
+				break
+			}
+		}
 		if err != nil {
 			log.Printf("[AMQP] consume setup error: %v (retrying)", err)
 			resetConnection()
@@ -94,100 +217,315 @@ func consumeLoop(agentID string, handle HandlerFunc) {
 			continue
 		}
 
-		log.Printf("[AMQP] consuming %s ...", queueName)
-		for d := range msgs {
-			var t Task
-			if err := json.Unmarshal(d.Body, &t); err != nil {
-				log.Printf("[TASK] invalid JSON: %v", err)
-				_ = d.Nack(false, false) // drop poison
-				continue
+		ctrlMsgs, err := c.Consume(controlQueue, "agent-"+agentID+"-control", false, false, false, false, nil)
+		if err != nil {
+			log.Printf("[AMQP] consume %s setup error: %v (retrying)", controlQueue, err)
+			resetConnection()
+			time.Sleep(3 * time.Second)
+			continue
+		}
+		go func() {
+			for d := range ctrlMsgs {
+				var t Task
+				if err := json.Unmarshal(d.Body, &t); err != nil {
+					log.Printf("[CONTROL] invalid JSON: %v", err)
+					_ = d.Ack(false)
+					continue
+				}
+				handleControl(opts, d, t)
 			}
+		}()
 
-			// Ignore si le message cible un autre agent
-			if t.AgentID != "" && t.AgentID != agentID {
-				_ = d.Ack(false)
-				continue
-			}
+		for i, l := range lanes[1:] {
+			go func(msgs <-chan amqp091.Delivery, l lane) {
+				for d := range msgs {
+					handleDelivery(opts, pool, d, l.name)
+				}
+			}(laneMsgs[i+1], l)
+		}
+		log.Printf("[AMQP] consuming %s (lanes=%v workers=%d prefetch=%d) ...", queueName, opts.Lanes, opts.Concurrency, opts.Prefetch)
+		for d := range laneMsgs[0] {
+			handleDelivery(opts, pool, d, "")
+		}
+		log.Printf("[AMQP] consumer stopped for %s (channel closed?), retrying...", queueName)
+		time.Sleep(2 * time.Second)
+	}
+}
 
-			result, hErr := handle(t)
-			ok := (hErr == nil)
+// handleDelivery décode une livraison de la lane l ("" = lane par défaut) et l'oriente:
+// contrôle, renvoi vers la lane de sa classe, scheduler (notBefore) ou exécution.
+func handleDelivery(opts ConsumerOpts, pool *workerPool, d amqp091.Delivery, l string) {
+	agentID := opts.AgentID
+	var t Task
+	if err := json.Unmarshal(d.Body, &t); err != nil {
+		log.Printf("[TASK] invalid JSON: %v", err)
+		if dlErr := deadLetter(agentID, d, DeadReasonInvalidJSON, CodedError(CodeInvalidInput, err), 0); dlErr != nil {
+			log.Printf("[AMQP] dead-letter error: %v", dlErr)
+			_ = d.Nack(false, false) // drop poison
+			return
+		}
+		_ = d.Ack(false)
+		return
+	}
 
-			if ok {
+	// Ignore si le message cible un autre agent
+	if t.AgentID != "" && t.AgentID != agentID {
+		_ = d.Ack(false)
+		return
+	}
+
+	// Messages de contrôle: traités immédiatement, hors pool
+	if t.Action == CancelAction {
+		handleControl(opts, d, t)
+		return
+	}
+
+	// Arrivée sur la lane par défaut alors que sa classe a sa propre queue: renvoyée
+	// (en cas d'échec de publication, exécutée ici plutôt que perdue)
+	if l == "" {
+		if target := laneOf(opts, t.Action); target != "" {
+			err := forwardToLane(agentID, d, target)
+			if err == nil {
 				_ = d.Ack(false)
-			} else {
-				log.Printf("[TASK] handler error | taskId=%s action=%s agentId=%s error=%v result=%#v",
-					t.TaskID, t.Action, t.AgentID, hErr, result,
-				)
-				_ = d.Nack(false, false)
+				return
 			}
+			log.Printf("[AMQP] forward taskId=%s to lane %s: %v (running from default lane)", t.TaskID, target, err)
+		}
+	}
 
-			// ---- Publier le résultat sur l'exchange results ----
-			corr := t.CorrelationID
-			if corr == "" {
-				corr = t.TaskID
-			}
+	emitEvent(opts, TaskEvent{Stage: StageReceived, Task: t})
 
-			// Détermine l'erreur principale à publier
-			errMsg := ""
-			if m, okCast := result.(map[string]any); okCast {
-				if s, ok := m["error"].(string); ok && s != "" {
-					errMsg = s
-				}
-			}
-			if errMsg == "" && hErr != nil {
-				errMsg = hErr.Error()
+	// notBefore dans le futur: retenue localement, le message est acquitté
+	if t.deferred(time.Now()) {
+		opts.sched.hold(d, t)
+		_ = d.Ack(false)
+		return
+	}
+	dispatch(opts, pool, d, t, nil)
+}
+
+// dispatch applique dédup et expiration puis lance l'exécution d'une tâche reçue
+// (ou arrivée à échéance dans le scheduler). preErr non nil: tâche réglée sans exécution.
+func dispatch(opts ConsumerOpts, pool *workerPool, d amqp091.Delivery, t Task, preErr error) {
+	// Idempotence: une tâche déjà vue (redelivery après reconnexion) n'est pas ré-exécutée
+	if opts.Store != nil && t.TaskID != "" {
+		decision, rec := opts.Store.Begin(t.TaskID, t.attemptNo())
+		switch decision {
+		case taskstore.Replay:
+			log.Printf("[TASK] duplicate taskId=%s (attempt %d done): replaying stored result", t.TaskID, rec.Attempt)
+			if len(rec.Result) > 0 {
+				publishTaskResult(t, rec.Result)
 			}
+			_ = d.Ack(false)
+			return
+		case taskstore.InProgress:
+			log.Printf("[TASK] duplicate taskId=%s already running: skipped", t.TaskID)
+			_ = d.Ack(false)
+			return
+		case taskstore.Interrupted:
+			log.Printf("[TASK] taskId=%s was interrupted by a previous agent run", t.TaskID)
+			// Non rejouable: un nouvel essai (attempt+1) passerait Begin et ré-exécuterait l'action
+			preErr = &TaskError{Code: CodeInterrupted, Retryable: false, Err: ErrTaskInterrupted}
+		}
+	}
+
+	// Expirée pendant l'absence de l'agent (ou en attente dans le scheduler): pas d'exécution
+	if preErr == nil {
+		preErr = t.expiredErr(time.Now())
+	}
+
+	// Réservation du verrou ici (et non dans la goroutine) pour garder l'ordre d'arrivée
+	var lock TaskLock
+	if opts.ReserveLock != nil && preErr == nil {
+		lock = opts.ReserveLock(t)
+	}
+
+	// Une goroutine par livraison; le pool borne l'exécution réelle
+	go processDelivery(opts, d, t, lock, pool, preErr)
+}
+
+// processDelivery exécute une tâche décodée: attente du verrou éventuel (sans
+// occuper de worker), exécution dans un slot du pool, ack/nack puis publication.
+// Si preErr est non nil, la tâche n'est pas exécutée et preErr tient lieu d'erreur.
+func processDelivery(opts ConsumerOpts, d amqp091.Delivery, t Task, lock TaskLock, pool *workerPool, preErr error) {
+	agentID := opts.AgentID
+	var lockWait time.Duration
+	if lock != nil {
+		lockWait = lock.Wait()
+		if lockWait > time.Second {
+			log.Printf("[TASK] lock acquired | taskId=%s action=%s waited=%s", t.TaskID, t.Action, lockWait)
+		}
+	}
 
-			res := map[string]any{
-				"taskId":     t.TaskID,
-				"agentId":    agentID,
-				"ok":         ok,
-				"result":     result,
-				"error":      errMsg,
-				"finishedAt": time.Now().UTC().Format(time.RFC3339),
+	var out any
+	var started time.Time
+	hErr := preErr
+	if hErr == nil {
+		release := pool.acquire(t.Action)
+		// expiresAt a pu passer pendant l'attente du verrou ou d'un worker
+		if hErr = t.expiredErr(time.Now()); hErr == nil {
+			started = time.Now()
+			emitEvent(opts, TaskEvent{Stage: StageStarted, Task: t})
+			out, hErr = opts.Handle(t)
+		}
+		release()
+	}
+	if lock != nil {
+		lock.Release()
+	}
+	ok := (hErr == nil)
+	attempt := t.attemptNo()
+
+	if ok {
+		_ = d.Ack(false)
+	} else {
+		result, _, _ := unwrapDiagnosed(out)
+		log.Printf("[TASK] handler error | taskId=%s action=%s agentId=%s attempt=%d/%d error=%v result=%#v",
+			t.TaskID, t.Action, t.AgentID, attempt, t.MaxAttempts, hErr, redact.Value(result),
+		)
+		if settleFailure(opts, d, t, attempt, hErr) {
+			if opts.Store != nil && t.TaskID != "" {
+				opts.Store.Finish(t.TaskID, attempt, false, nil)
 			}
+			code, _ := Classify(hErr)
+			emitEvent(opts, TaskEvent{Stage: StageFinished, Task: t, Status: StatusRetrying, ErrorCode: code, Duration: since(started)})
+			return // un nouvel essai est planifié: pas de résultat final
+		}
+	}
 
-			b, _ := json.Marshal(res)
+	res := BuildTaskResult(agentID, t, started, out, hErr)
+	if lock != nil {
+		ms := lockWait.Milliseconds()
+		res.LockWaitMs = &ms
+	}
 
-			rk := "task." + t.TaskID
-			if err := c.Publish(
-				ResultsEx, rk,
-				true,  // mandatory
-				false, // immediate
+	// Secrets masqués avant publication et persistance (taskstore)
+	b := res.Encode()
+	if opts.Store != nil && t.TaskID != "" {
+		opts.Store.Finish(t.TaskID, attempt, ok, b)
+	}
+	publishTaskResult(t, b)
+	emitEvent(opts, TaskEvent{Stage: StageFinished, Task: t, Status: res.Status, ErrorCode: res.ErrorCode, Duration: since(started)})
+
+	// ---- Hook post-publication (ex: déclencher inventory.refresh.light) ----
+	if AfterResult != nil {
+		go AfterResult(t) // non bloquant
+	}
+}
+
+// handleControl traite un message de contrôle (task.cancel) puis publie son propre résultat.
+func handleControl(opts ConsumerOpts, d amqp091.Delivery, t Task) {
+	defer func() { _ = d.Ack(false) }()
+	if t.AgentID != "" && t.AgentID != opts.AgentID {
+		return
+	}
+	if t.Action != CancelAction {
+		log.Printf("[CONTROL] unknown control action %q", t.Action)
+		return
+	}
+
+	target, _ := t.Data["taskId"].(string)
+	reason, _ := t.Data["reason"].(string)
+	running, scheduled := false, false
+	if target != "" && opts.Cancel != nil {
+		running = opts.Cancel(target, reason)
+	}
+	if target != "" && !running && opts.sched != nil {
+		scheduled = opts.sched.cancel(target, reason)
+	}
+	log.Printf("[CONTROL] cancel taskId=%s running=%v scheduled=%v", target, running, scheduled)
+
+	if t.TaskID == "" {
+		return
+	}
+	res := newTaskResult(opts.AgentID, t, time.Time{})
+	res.Result = map[string]any{"taskId": target, "running": running, "scheduled": scheduled}
+	if target == "" {
+		res.fail(StatusFailed, "missing data.taskId", CodedError(CodeInvalidInput, errors.New("missing data.taskId")))
+	}
+	publishTaskResult(t, res.Encode())
+}
+
+// settleFailure acquitte une livraison en échec selon Attempt/MaxAttempts:
+// nouvel essai différé si possible (renvoie true), sinon dead-letter (tâches
+// avec MaxAttempts) ou rejet simple (tâches sans politique de rejeu).
+func settleFailure(opts ConsumerOpts, d amqp091.Delivery, t Task, attempt int, hErr error) bool {
+	if errors.Is(hErr, ErrTaskCancelled) || errors.Is(hErr, ErrTaskExpired) {
+		_ = d.Ack(false) // annulation voulue / tâche périmée: ni rejeu ni dead-letter
+		return false
+	}
+	if t.MaxAttempts <= 0 {
+		_ = d.Nack(false, false)
+		return false
+	}
+
+	reason := DeadReasonExhausted
+	if IsPermanent(hErr) {
+		reason = DeadReasonNotRetryable
+	} else if attempt < t.MaxAttempts {
+		delay := retryDelay(attempt, opts.RetryBase, opts.RetryMax)
+		err := scheduleRetry(opts.AgentID, d, attempt+1, delay, hErr)
+		if err == nil {
+			_ = d.Ack(false)
+			log.Printf("[TASK] retry scheduled | taskId=%s attempt=%d/%d in=%s", t.TaskID, attempt+1, t.MaxAttempts, delay)
+			publishRetryEvent(opts.AgentID, t, attempt, delay, hErr)
+			return true
+		}
+		log.Printf("[AMQP] schedule retry error (taskId=%s): %v", t.TaskID, err)
+	}
+
+	if err := deadLetter(opts.AgentID, d, reason, hErr, attempt); err != nil {
+		log.Printf("[AMQP] dead-letter error (taskId=%s): %v", t.TaskID, err)
+		_ = d.Nack(false, false)
+		return false
+	}
+	_ = d.Ack(false)
+	return false
+}
+
+// publishTaskResult publie le résultat sur l'exchange results (rk task.<id>)
+// et, si demandé, sur la queue replyTo (compat).
+func publishTaskResult(t Task, body []byte) {
+	corr := t.CorrelationID
+	if corr == "" {
+		corr = t.TaskID
+	}
+
+	// ---- Publier le résultat sur l'exchange results ----
+	rk := "task." + t.TaskID
+	if err := publishWithRetry(func(c *amqp091.Channel) error {
+		return c.Publish(
+			ResultsEx, rk,
+			true,  // mandatory
+			false, // immediate
+			amqp091.Publishing{
+				ContentType:   "application/json",
+				DeliveryMode:  amqp091.Persistent,
+				CorrelationId: corr,
+				Body:          body,
+			},
+		)
+	}); err != nil {
+		log.Printf("[AMQP] publish result (exchange) error: %v", err)
+	}
+
+	// ---- Optionnel: compat queue replyTo ----
+	if t.ReplyTo != "" {
+		if err := publishWithRetry(func(c *amqp091.Channel) error {
+			_, _ = c.QueueDeclare(t.ReplyTo, true, false, false, false, nil)
+			return c.Publish(
+				"", t.ReplyTo,
+				true,
+				false,
 				amqp091.Publishing{
 					ContentType:   "application/json",
 					DeliveryMode:  amqp091.Persistent,
 					CorrelationId: corr,
-					Body:          b,
+					Body:          body,
 				},
-			); err != nil {
-				log.Printf("[AMQP] publish result (exchange) error: %v", err)
-			}
-
-			// ---- Optionnel: compat queue replyTo ----
-			if t.ReplyTo != "" {
-				_, _ = c.QueueDeclare(t.ReplyTo, true, false, false, false, nil)
-				if err := c.Publish(
-					"", t.ReplyTo,
-					true,
-					false,
-					amqp091.Publishing{
-						ContentType:   "application/json",
-						DeliveryMode:  amqp091.Persistent,
-						CorrelationId: corr,
-						Body:          b,
-					},
-				); err != nil {
-					log.Printf("[AMQP] publish result (replyTo) error: %v", err)
-				}
-			}
-
-			// ---- Hook post-publication (ex: déclencher inventory.refresh.light) ----
-			if AfterResult != nil {
-				go AfterResult(t) // non bloquant
-			}
+			)
+		}); err != nil {
+			log.Printf("[AMQP] publish result (replyTo) error: %v", err)
 		}
-		log.Printf("[AMQP] consumer stopped for %s (channel closed?), retrying...", queueName)
-		time.Sleep(2 * time.Second)
 	}
 }
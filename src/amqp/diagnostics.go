@@ -0,0 +1,17 @@
+package amqp
+
+// Diagnosed: résultat de handler accompagné d'un bloc de diagnostics d'exécution,
+// publié à côté de "result" (champ "diagnostics" du résultat de tâche).
+type Diagnosed struct {
+	Result      any
+	Diagnostics any
+	ExitCode    int // -1: script non lancé ou tué (pas de code de sortie)
+}
+
+// unwrapDiagnosed sépare le résultat métier des diagnostics et du code de sortie éventuels.
+func unwrapDiagnosed(result any) (any, any, int) {
+	if d, ok := result.(Diagnosed); ok {
+		return d.Result, d.Diagnostics, d.ExitCode
+	}
+	return result, nil, -1
+}
@@ -0,0 +1,124 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode: taxonomie stable des échecs, publiée dans le résultat (errorCode).
+// Le controller s'appuie dessus plutôt que sur le texte de "error".
+type ErrorCode string
+
+const (
+	CodeInvalidInput       ErrorCode = "INVALID_INPUT"       // payload refusé (schéma, champ manquant, ...)
+	CodeNotFound           ErrorCode = "NOT_FOUND"           // VM / image / cible inexistante
+	CodeConflict           ErrorCode = "CONFLICT"            // état incompatible (nom déjà pris, VM démarrée, ...)
+	CodeTimeout            ErrorCode = "TIMEOUT"             // délai de l'action dépassé
+	CodeCancelled          ErrorCode = "CANCELLED"           // task.cancel
+	CodeCapabilityDisabled ErrorCode = "CAPABILITY_DISABLED" // action non couverte par la config
+	CodeUnknownAction      ErrorCode = "UNKNOWN_ACTION"      // aucun script pour l'action
+	CodeActionFailed       ErrorCode = "ACTION_FAILED"       // le script a échoué sans préciser de code
+	CodeScriptCrash        ErrorCode = "SCRIPT_CRASH"        // échec sans sortie JSON exploitable
+	CodeBackendUnavailable ErrorCode = "BACKEND_UNAVAILABLE" // Hyper-V / iSCSI / pwsh indisponible
+	CodePermissionDenied   ErrorCode = "PERMISSION_DENIED"   // droits insuffisants
+	CodeInterrupted        ErrorCode = "INTERRUPTED"         // agent redémarré pendant l'exécution
+	CodeInternal           ErrorCode = "INTERNAL"            // erreur côté agent
+	CodeIntegrity          ErrorCode = "INTEGRITY_VIOLATION" // script / binaire modifié (manifest signé)
+	CodeExpired            ErrorCode = "EXPIRED"             // expiresAt dépassé avant l'exécution
+	CodeDryRunUnsupported  ErrorCode = "DRY_RUN_UNSUPPORTED" // dryRun demandé, l'action ne le déclare pas
+	CodeOutputTooLarge     ErrorCode = "OUTPUT_TOO_LARGE"    // STDOUT au-delà de scriptStdoutMaxKB
+)
+
+// retryableByDefault: rejouer a-t-il une chance de réussir ?
+var retryableByDefault = map[ErrorCode]bool{
+	CodeInvalidInput:       false,
+	CodeNotFound:           false,
+	CodeConflict:           false,
+	CodeTimeout:            true,
+	CodeCancelled:          false,
+	CodeCapabilityDisabled: false,
+	CodeUnknownAction:      false,
+	CodeActionFailed:       false, // effet de bord inconnu: le script active le rejeu avec "retryable": true
+	CodeScriptCrash:        true,
+	CodeBackendUnavailable: true,
+	CodePermissionDenied:   false,
+	CodeInterrupted:        false, // état VM inconnu: jamais rejouée implicitement (dead-letter)
+	CodeInternal:           true,
+	CodeIntegrity:          false,
+	CodeExpired:            false,
+	CodeDryRunUnsupported:  false,
+	CodeOutputTooLarge:     false,
+}
+
+// Known indique si le code fait partie de la taxonomie.
+func (c ErrorCode) Known() bool {
+	_, ok := retryableByDefault[c]
+	return ok
+}
+
+// Retryable: valeur par défaut du code (un code inconnu est considéré rejouable).
+func (c ErrorCode) Retryable() bool {
+	r, ok := retryableByDefault[c]
+	return r || !ok
+}
+
+// TaskError porte le code et la politique de rejeu d'un échec de handler.
+type TaskError struct {
+	Code      ErrorCode
+	Retryable bool
+	Err       error
+}
+
+func (e *TaskError) Error() string { return e.Err.Error() }
+func (e *TaskError) Unwrap() error { return e.Err }
+
+// CodedError enveloppe err avec un code, rejouable selon le défaut du code.
+func CodedError(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TaskError{Code: code, Retryable: code.Retryable(), Err: err}
+}
+
+// Errorf: raccourci CodedError(code, fmt.Errorf(...)).
+func Errorf(code ErrorCode, format string, args ...any) error {
+	return CodedError(code, fmt.Errorf(format, args...))
+}
+
+// Permanent enveloppe err pour indiquer qu'un nouvel essai échouerait de la même façon
+// (script introuvable, entrée invalide, ...). La tâche ne sera pas rejouée; le code
+// éventuel de err est conservé.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	code, _ := Classify(err)
+	return &TaskError{Code: code, Retryable: false, Err: err}
+}
+
+// IsPermanent indique si err (ou une erreur enveloppée) est marquée non rejouable.
+func IsPermanent(err error) bool {
+	var te *TaskError
+	return errors.As(err, &te) && !te.Retryable
+}
+
+// Classify renvoie le code et le caractère rejouable d'une erreur de handler.
+// Les erreurs non typées sont classées INTERNAL (rejouables).
+func Classify(err error) (ErrorCode, bool) {
+	var te *TaskError
+	switch {
+	case err == nil:
+		return "", false
+	case errors.As(err, &te):
+		return te.Code, te.Retryable
+	case errors.Is(err, ErrTaskTimeout):
+		return CodeTimeout, true
+	case errors.Is(err, ErrTaskCancelled):
+		return CodeCancelled, false
+	case errors.Is(err, ErrTaskInterrupted):
+		return CodeInterrupted, false
+	case errors.Is(err, ErrTaskExpired):
+		return CodeExpired, false
+	}
+	return CodeInternal, true
+}
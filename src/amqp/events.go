@@ -0,0 +1,33 @@
+package amqp
+
+import "time"
+
+// Étapes du cycle de vie d'une tâche remontées à ConsumerOpts.OnEvent.
+const (
+	StageReceived = "received" // message décodé, destiné à cet agent
+	StageStarted  = "started"  // handler lancé (verrou VM obtenu)
+	StageFinished = "finished" // résultat publié, ou nouvel essai planifié (Status "retrying")
+)
+
+// TaskEvent: étape d'une tâche (journal d'audit local).
+type TaskEvent struct {
+	Stage     string
+	Task      Task
+	Status    TaskStatus    // finished: succeeded | failed | timeout | cancelled | expired | retrying
+	ErrorCode ErrorCode     // finished en échec
+	Duration  time.Duration // finished: depuis started
+}
+
+func emitEvent(opts ConsumerOpts, ev TaskEvent) {
+	if opts.OnEvent != nil {
+		opts.OnEvent(ev)
+	}
+}
+
+// since: durée depuis t (0 si la tâche n'a pas démarré).
+func since(t time.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+	return time.Since(t)
+}
@@ -0,0 +1,91 @@
+package amqp
+
+import (
+	"fmt"
+	"log"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// lane: queue de tâches dédiée à une classe d'action. La lane par défaut ("")
+// est agent.<id>.tasks (rk <agentId>); les autres agent.<id>.tasks.<lane> (rk <agentId>.<lane>).
+type lane struct {
+	name  string
+	queue string
+	key   string
+}
+
+func taskLanes(opts ConsumerOpts) []lane {
+	out := []lane{{name: "", queue: fmt.Sprintf("agent.%s.tasks", opts.AgentID), key: opts.AgentID}}
+	for _, name := range opts.Lanes {
+		out = append(out, lane{
+			name:  name,
+			queue: fmt.Sprintf("agent.%s.tasks.%s", opts.AgentID, name),
+			key:   opts.AgentID + "." + name,
+		})
+	}
+	return out
+}
+
+// validLanes écarte les noms vides, en double ou réservés (control, retry, dead).
+func validLanes(names []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		switch {
+		case name == "" || seen[name]:
+		case name == "control" || name == "retry" || name == "dead":
+			log.Printf("[AMQP] lane %q ignored (reserved name)", name)
+		default:
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// laneOf: lane d'une action (sa classe si une queue lui est dédiée, sinon la lane par défaut).
+func laneOf(opts ConsumerOpts, action string) string {
+	if opts.ClassOf == nil {
+		return ""
+	}
+	class := opts.ClassOf(action)
+	for _, name := range opts.Lanes {
+		if name == class {
+			return name
+		}
+	}
+	return ""
+}
+
+// forwardToLane republie tel quel un message arrivé sur la lane par défaut vers la
+// queue de sa classe: la queue par défaut se vide sans attendre les tâches bulk.
+func forwardToLane(agentID string, d amqp091.Delivery, name string) error {
+	return publishWithRetry(func(c *amqp091.Channel) error {
+		return c.Publish(
+			JobsEx, agentID+"."+name,
+			true,  // mandatory
+			false, // immediate
+			amqp091.Publishing{
+				ContentType:   d.ContentType,
+				DeliveryMode:  amqp091.Persistent,
+				CorrelationId: d.CorrelationId,
+				Headers:       copyHeaders(d.Headers),
+				Body:          d.Body,
+			},
+		)
+	})
+}
+
+// declareLanes déclare les queues des lanes et leurs bindings sur l'exchange jobs.
+func declareLanes(c *amqp091.Channel, lanes []lane) error {
+	for _, l := range lanes {
+		if _, err := c.QueueDeclare(l.queue, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declare %s: %w", l.queue, err)
+		}
+		if err := c.QueueBind(l.queue, l.key, JobsEx, false, nil); err != nil {
+			return fmt.Errorf("bind %s to %s: %w", l.queue, JobsEx, err)
+		}
+	}
+	return nil
+}
@@ -9,13 +9,16 @@ import (
 	"sync"
 	"time"
 
+	"openhvx-agent/redact"
+
 	amqp091 "github.com/rabbitmq/amqp091-go"
 )
 
 const (
-	JobsEx      = "jobs"            // direct
-	TelemetryEx = "agent.telemetry" // topic
-	ResultsEx   = "results"         // topic
+	JobsEx       = "jobs"            // direct
+	TelemetryEx  = "agent.telemetry" // topic
+	ResultsEx    = "results"         // topic
+	DeadLetterEx = "jobs.dlx"        // direct (rk = agentID)
 )
 
 var (
@@ -49,21 +52,23 @@ func ClosePublisher() {
 }
 
 type heartbeat struct {
-	Version      string   `json:"version"`
-	AgentID      string   `json:"agentId"`
-	Timestamp    string   `json:"ts"`
-	Host         string   `json:"host"`
-	Capabilities []string `json:"capabilities"`
+	Version      string            `json:"version"`
+	AgentID      string            `json:"agentId"`
+	Timestamp    string            `json:"ts"`
+	Host         string            `json:"host"`
+	Capabilities []string          `json:"capabilities"`
+	Actions      map[string]string `json:"actions,omitempty"` // action -> version (manifest)
 }
 
-// PublishHeartbeat envoie un heartbeat sans notion de tenant.
-func PublishHeartbeat(agentID string, host string, caps []string) error {
+// PublishHeartbeat envoie un heartbeat sans notion de tenant (capabilities servies + versions des actions).
+func PublishHeartbeat(agentID string, host string, caps []string, actions map[string]string) error {
 	hb := heartbeat{
-		Version:      "0.1.0",
+		Version:      AgentVersion,
 		AgentID:      agentID,
 		Host:         host,
 		Timestamp:    time.Now().UTC().Format(time.RFC3339),
 		Capabilities: caps,
+		Actions:      actions,
 	}
 	body, _ := json.Marshal(hb)
 	rk := "heartbeat." + agentID
@@ -170,6 +175,74 @@ func PublishInventoryJSONWithMeta(opts InventoryPublishOpts) error {
 	})
 }
 
+type schemasEnvelope struct {
+	AgentID   string                     `json:"agentId"`
+	Timestamp string                     `json:"ts"`
+	Schemas   map[string]json.RawMessage `json:"schemas"` // action -> JSON Schema de data
+}
+
+// PublishActionSchemas publie les schémas d'entrée des actions (validation côté controller).
+func PublishActionSchemas(agentID string, schemas map[string]json.RawMessage) error {
+	env := schemasEnvelope{
+		AgentID:   agentID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Schemas:   schemas,
+	}
+	body, _ := json.Marshal(env)
+	rk := "schemas." + agentID
+
+	log.Printf("[AMQP] Publishing %d action schemas to %s rk=%s", len(schemas), TelemetryEx, rk)
+
+	return publishWithRetry(func(c *amqp091.Channel) error {
+		return c.Publish(
+			TelemetryEx, rk,
+			true,  // mandatory
+			false, // immediate
+			amqp091.Publishing{
+				ContentType:  "application/json",
+				DeliveryMode: amqp091.Persistent,
+				Body:         body,
+			},
+		)
+	})
+}
+
+type alertEnvelope struct {
+	AgentID   string `json:"agentId"`
+	Timestamp string `json:"ts"`
+	Kind      string `json:"kind"`     // ex: "integrity"
+	Severity  string `json:"severity"` // warning | critical
+	Details   any    `json:"details"`
+}
+
+// PublishAlert publie une alerte de sécurité / exploitation (routing key alert.<agentId>).
+func PublishAlert(agentID, kind, severity string, details any) error {
+	env := alertEnvelope{
+		AgentID:   agentID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Kind:      kind,
+		Severity:  severity,
+		Details:   details,
+	}
+	body, _ := json.Marshal(env)
+	rk := "alert." + agentID
+
+	log.Printf("[AMQP] Publishing %s alert to %s rk=%s", kind, TelemetryEx, rk)
+
+	return publishWithRetry(func(c *amqp091.Channel) error {
+		return c.Publish(
+			TelemetryEx, rk,
+			true,  // mandatory
+			false, // immediate
+			amqp091.Publishing{
+				ContentType:  "application/json",
+				DeliveryMode: amqp091.Persistent,
+				Body:         body,
+			},
+		)
+	})
+}
+
 // --------- Internals (reconnexion + canal) ----------
 
 func ensureChannelWithRetry(attempts int, delay time.Duration) (*amqp091.Channel, error) {
@@ -241,6 +314,9 @@ func declareExchanges(c *amqp091.Channel) error {
 	if err := c.ExchangeDeclare(ResultsEx, "topic", true, false, false, false, nil); err != nil {
 		return fmt.Errorf("declare exchange %s: %w", ResultsEx, err)
 	}
+	if err := c.ExchangeDeclare(DeadLetterEx, "direct", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare exchange %s: %w", DeadLetterEx, err)
+	}
 	return nil
 }
 
@@ -303,3 +379,44 @@ func startReturnLogger(c *amqp091.Channel) {
 		}
 	}()
 }
+
+type taskProgress struct {
+	TaskID    string  `json:"taskId"`
+	AgentID   string  `json:"agentId"`
+	Seq       int     `json:"seq"`
+	Timestamp string  `json:"ts"`
+	Percent   float64 `json:"percent"`
+	Step      string  `json:"step,omitempty"`
+	Message   string  `json:"message,omitempty"`
+}
+
+// PublishTaskProgress publie un point d'avancement sur l'exchange results (rk task.<id>.progress).
+func PublishTaskProgress(agentID string, t Task, seq int, percent float64, step, message string) error {
+	body, _ := json.Marshal(taskProgress{
+		TaskID:    t.TaskID,
+		AgentID:   agentID,
+		Seq:       seq,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Percent:   percent,
+		Step:      step,
+		Message:   redact.String(message),
+	})
+	corr := t.CorrelationID
+	if corr == "" {
+		corr = t.TaskID
+	}
+
+	return publishWithRetry(func(c *amqp091.Channel) error {
+		return c.Publish(
+			ResultsEx, "task."+t.TaskID+".progress",
+			false, // non mandatory: personne n'écoute forcément la progression
+			false, // immediate
+			amqp091.Publishing{
+				ContentType:   "application/json",
+				DeliveryMode:  amqp091.Transient,
+				CorrelationId: corr,
+				Body:          body,
+			},
+		)
+	})
+}
@@ -0,0 +1,129 @@
+package amqp
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"openhvx-agent/redact"
+)
+
+// AgentVersion: version de l'agent publiée dans les heartbeats et les résultats de tâche.
+const AgentVersion = "0.1.0"
+
+// ResultVersion: version du schéma de TaskResult (champ "v"), à incrémenter si un champ change de sens.
+const ResultVersion = 1
+
+// TaskStatus: état final d'une tâche.
+type TaskStatus string
+
+const (
+	StatusSucceeded TaskStatus = "succeeded"
+	StatusFailed    TaskStatus = "failed"
+	StatusTimeout   TaskStatus = "timeout"
+	StatusCancelled TaskStatus = "cancelled"
+	StatusExpired   TaskStatus = "expired"
+	StatusRetrying  TaskStatus = "retrying" // événements seulement: un nouvel essai est planifié
+)
+
+// TaskResult: enveloppe publiée sur l'exchange results (rk task.<id>) et sur replyTo.
+// ok, result et error gardent leur place pour les anciens consommateurs.
+type TaskResult struct {
+	V            int        `json:"v"`
+	TaskID       string     `json:"taskId"`
+	AgentID      string     `json:"agentId"`
+	AgentVersion string     `json:"agentVersion"`
+	Action       string     `json:"action,omitempty"`
+	TenantID     string     `json:"tenantId,omitempty"`
+	OK           bool       `json:"ok"`
+	Status       TaskStatus `json:"status"`
+	Result       any        `json:"result"`
+	Error        string     `json:"error"`
+	ErrorCode    ErrorCode  `json:"errorCode,omitempty"`
+	Retryable    *bool      `json:"retryable,omitempty"` // seulement en échec
+	Attempt      int        `json:"attempt"`
+	ExitCode     *int       `json:"exitCode,omitempty"`  // code de sortie du script, si lancé
+	StartedAt    string     `json:"startedAt,omitempty"` // absent si la tâche n'a pas été exécutée
+	FinishedAt   string     `json:"finishedAt"`
+	DurationMs   int64      `json:"durationMs"`
+	TimedOut     bool       `json:"timedOut,omitempty"`
+	DryRun       bool       `json:"dryRun,omitempty"`
+	LockWaitMs   *int64     `json:"lockWaitMs,omitempty"` // seulement si la tâche a pris un verrou VM
+	Diagnostics  any        `json:"diagnostics,omitempty"`
+}
+
+// newTaskResult prépare l'enveloppe d'une tâche (statut succeeded, horodatée maintenant).
+func newTaskResult(agentID string, t Task, started time.Time) TaskResult {
+	now := time.Now()
+	r := TaskResult{
+		V:            ResultVersion,
+		TaskID:       t.TaskID,
+		AgentID:      agentID,
+		AgentVersion: AgentVersion,
+		Action:       t.Action,
+		TenantID:     t.TenantID,
+		OK:           true,
+		Status:       StatusSucceeded,
+		Attempt:      t.attemptNo(),
+		FinishedAt:   now.UTC().Format(time.RFC3339),
+		DryRun:       t.DryRun,
+	}
+	if !started.IsZero() {
+		r.StartedAt = started.UTC().Format(time.RFC3339)
+		r.DurationMs = now.Sub(started).Milliseconds()
+	}
+	return r
+}
+
+// BuildTaskResult assemble l'enveloppe finale d'une tâche à partir de la sortie du handler
+// (Diagnosed accepté) et de son erreur: c'est le corps publié sur results / replyTo.
+func BuildTaskResult(agentID string, t Task, started time.Time, out any, hErr error) TaskResult {
+	result, diagnostics, exitCode := unwrapDiagnosed(out)
+	r := newTaskResult(agentID, t, started)
+	r.Result = result
+	r.Diagnostics = diagnostics
+	if exitCode >= 0 {
+		r.ExitCode = &exitCode
+	}
+	// Erreur principale: celle du script si présente, sinon celle du handler
+	errMsg := ""
+	if m, ok := result.(map[string]any); ok {
+		errMsg, _ = m["error"].(string)
+	}
+	if hErr == nil {
+		r.Error = errMsg
+		return r
+	}
+
+	status := StatusFailed
+	switch {
+	case errors.Is(hErr, ErrTaskTimeout):
+		status = StatusTimeout
+		r.TimedOut = true
+	case errors.Is(hErr, ErrTaskCancelled):
+		status = StatusCancelled
+	case errors.Is(hErr, ErrTaskExpired):
+		status = StatusExpired
+	}
+	if errMsg == "" || status != StatusFailed {
+		errMsg = hErr.Error()
+	}
+	r.fail(status, errMsg, hErr)
+	return r
+}
+
+// fail marque le résultat en échec avec le code et le caractère rejouable de err.
+func (r *TaskResult) fail(status TaskStatus, msg string, err error) {
+	code, retryable := Classify(err)
+	r.OK = false
+	r.Status = status
+	r.Error = msg
+	r.ErrorCode = code
+	r.Retryable = &retryable
+}
+
+// Encode masque les secrets puis sérialise le résultat (même corps pour results, replyTo et taskstore).
+func (r TaskResult) Encode() []byte {
+	b, _ := json.Marshal(r)
+	return redact.JSON(b)
+}
@@ -0,0 +1,150 @@
+package amqp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"openhvx-agent/redact"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// Raisons de dead-letter (header x-openhvx-reason)
+const (
+	DeadReasonInvalidJSON  = "invalid-json"
+	DeadReasonExhausted    = "retries-exhausted"
+	DeadReasonNotRetryable = "non-retryable"
+)
+
+// retryDelay: backoff exponentiel base * 2^(attempt-1), plafonné, arrondi à la seconde
+// (une delay queue par valeur de délai).
+func retryDelay(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d.Round(time.Second)
+}
+
+// scheduleRetry republie la tâche (attempt+1) dans une delay queue dont le TTL
+// la renvoie, à expiration, vers l'exchange jobs avec la routing key de l'agent.
+func scheduleRetry(agentID string, d amqp091.Delivery, next int, delay time.Duration, cause error) error {
+	body, err := withAttempt(d.Body, next)
+	if err != nil {
+		return err
+	}
+	queue := fmt.Sprintf("agent.%s.tasks.retry.%ds", agentID, int(delay.Seconds()))
+	args := amqp091.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    JobsEx,
+		"x-dead-letter-routing-key": agentID,
+	}
+	headers := copyHeaders(d.Headers)
+	headers["x-openhvx-last-error"] = redact.String(cause.Error())
+
+	return publishWithRetry(func(c *amqp091.Channel) error {
+		if _, err := c.QueueDeclare(queue, true, false, false, false, args); err != nil {
+			return fmt.Errorf("declare %s: %w", queue, err)
+		}
+		return c.Publish(
+			"", queue,
+			true,  // mandatory
+			false, // immediate
+			amqp091.Publishing{
+				ContentType:   "application/json",
+				DeliveryMode:  amqp091.Persistent,
+				CorrelationId: d.CorrelationId,
+				Headers:       headers,
+				Body:          body,
+			},
+		)
+	})
+}
+
+// deadLetter route le message d'origine vers l'exchange de dead-letter, la raison
+// de l'échec étant portée par les headers x-openhvx-*.
+func deadLetter(agentID string, d amqp091.Delivery, reason string, cause error, attempts int) error {
+	headers := copyHeaders(d.Headers)
+	headers["x-openhvx-reason"] = reason
+	headers["x-openhvx-agent-id"] = agentID
+	headers["x-openhvx-failed-at"] = time.Now().UTC().Format(time.RFC3339)
+	if cause != nil {
+		code, _ := Classify(cause)
+		headers["x-openhvx-error"] = redact.String(cause.Error())
+		headers["x-openhvx-error-code"] = string(code)
+	}
+	if attempts > 0 {
+		headers["x-openhvx-attempts"] = int32(attempts)
+	}
+
+	return publishWithRetry(func(c *amqp091.Channel) error {
+		return c.Publish(
+			DeadLetterEx, agentID,
+			true,  // mandatory
+			false, // immediate
+			amqp091.Publishing{
+				ContentType:   d.ContentType,
+				DeliveryMode:  amqp091.Persistent,
+				CorrelationId: d.CorrelationId,
+				Headers:       headers,
+				Body:          d.Body,
+			},
+		)
+	})
+}
+
+// publishRetryEvent informe le controller qu'un essai a échoué et qu'un autre est planifié
+// (rk task.<id>.retry; le résultat final reste publié sur task.<id>).
+func publishRetryEvent(agentID string, t Task, attempt int, delay time.Duration, cause error) {
+	code, _ := Classify(cause)
+	ev := map[string]any{
+		"taskId":      t.TaskID,
+		"agentId":     agentID,
+		"attempt":     attempt,
+		"nextAttempt": attempt + 1,
+		"maxAttempts": t.MaxAttempts,
+		"retryInMs":   delay.Milliseconds(),
+		"error":       redact.String(cause.Error()),
+		"errorCode":   code,
+		"ts":          time.Now().UTC().Format(time.RFC3339),
+	}
+	b, _ := json.Marshal(ev)
+	if err := publishWithRetry(func(c *amqp091.Channel) error {
+		return c.Publish(
+			ResultsEx, "task."+t.TaskID+".retry",
+			false, // non mandatory: événement informatif
+			false,
+			amqp091.Publishing{
+				ContentType:   "application/json",
+				DeliveryMode:  amqp091.Persistent,
+				CorrelationId: t.CorrelationID,
+				Body:          b,
+			},
+		)
+	}); err != nil {
+		log.Printf("[AMQP] publish retry event error: %v", err)
+	}
+}
+
+// withAttempt réécrit "attempt" dans le corps JSON en conservant les autres champs tels quels.
+func withAttempt(body []byte, attempt int) ([]byte, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	m["attempt"], _ = json.Marshal(attempt)
+	return json.Marshal(m)
+}
+
+func copyHeaders(h amqp091.Table) amqp091.Table {
+	out := amqp091.Table{}
+	for k, v := range h {
+		out[k] = v
+	}
+	return out
+}
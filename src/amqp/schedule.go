@@ -0,0 +1,281 @@
+package amqp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrTaskExpired: la tâche a dépassé son expiresAt avant d'être exécutée
+// (résultat publié avec status "expired", jamais rejouée ni dead-letterée).
+var ErrTaskExpired = errors.New("task expired")
+
+// expiredErr renvoie une erreur ErrTaskExpired si expiresAt est dépassé à now.
+func (t Task) expiredErr(now time.Time) error {
+	if t.ExpiresAt.IsZero() || now.Before(t.ExpiresAt) {
+		return nil
+	}
+	return fmt.Errorf("%w at %s", ErrTaskExpired, t.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// deferred: notBefore dans le futur (et pas d'expiration avant l'échéance).
+func (t Task) deferred(now time.Time) bool {
+	if t.NotBefore.IsZero() || !now.Before(t.NotBefore) {
+		return false
+	}
+	return t.ExpiresAt.IsZero() || t.NotBefore.Before(t.ExpiresAt)
+}
+
+// scheduledTask: tâche retenue jusqu'à NotBefore, persistée sous ConsumerOpts.ScheduleDir.
+type scheduledTask struct {
+	Key           string          `json:"key"`
+	NotBefore     time.Time       `json:"notBefore"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+	ContentType   string          `json:"contentType,omitempty"`
+	Body          json.RawMessage `json:"body"`
+}
+
+// scheduler: file locale des tâches différées. Le message AMQP est acquitté dès que
+// la tâche est persistée; le fichier n'est supprimé qu'une fois la tâche réglée
+// (ack de la livraison synthétique), un redémarrage en cours d'exécution la
+// représente donc au taskstore qui la signale INTERRUPTED.
+type scheduler struct {
+	dir     string // vide: en mémoire seulement (perdu au redémarrage)
+	mu      sync.Mutex
+	pending map[string]scheduledTask
+	wake    chan struct{}
+	run     func(d amqp091.Delivery, t Task, preErr error)
+}
+
+func newScheduler(dir string) *scheduler {
+	s := &scheduler{dir: dir, pending: map[string]scheduledTask{}, wake: make(chan struct{}, 1)}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Printf("[SCHED] mkdir %s: %v (scheduled tasks kept in memory only)", dir, err)
+			s.dir = ""
+		}
+	}
+	return s
+}
+
+// start restaure les tâches persistées puis lance la boucle. run ne doit être appelée
+// qu'une fois les options du consumer complètes (scheduler compris).
+func (s *scheduler) start(run func(amqp091.Delivery, Task, error)) {
+	s.run = run
+	s.load()
+	go s.loop()
+}
+
+// hold retient la tâche jusqu'à son notBefore.
+func (s *scheduler) hold(d amqp091.Delivery, t Task) {
+	st := scheduledTask{
+		Key:           scheduleKey(t, d.Body),
+		NotBefore:     t.NotBefore,
+		CorrelationID: d.CorrelationId,
+		ContentType:   d.ContentType,
+		Body:          json.RawMessage(d.Body),
+	}
+	if err := s.persist(st); err != nil {
+		log.Printf("[SCHED] persist taskId=%s: %v (kept in memory only)", t.TaskID, err)
+	}
+	s.mu.Lock()
+	s.pending[st.Key] = st
+	n := len(s.pending)
+	s.mu.Unlock()
+	log.Printf("[SCHED] held taskId=%s action=%s until %s (%d pending)", t.TaskID, t.Action, t.NotBefore.UTC().Format(time.RFC3339), n)
+	s.poke()
+}
+
+// cancel retire une tâche différée (task.cancel) et publie son résultat "cancelled".
+func (s *scheduler) cancel(taskID, reason string) bool {
+	if taskID == "" {
+		return false
+	}
+	s.mu.Lock()
+	st, ok := s.pending[taskID]
+	delete(s.pending, taskID)
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	d, t, err := s.delivery(st)
+	if err != nil {
+		s.forget(st.Key)
+		return true
+	}
+	cause := ErrTaskCancelled
+	if reason != "" {
+		cause = fmt.Errorf("%w: %s", ErrTaskCancelled, reason)
+	}
+	s.run(d, t, cause)
+	return true
+}
+
+func (s *scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) loop() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		now := time.Now()
+		var due []scheduledTask
+		next := now.Add(time.Hour)
+		s.mu.Lock()
+		for k, st := range s.pending {
+			if !now.Before(st.NotBefore) {
+				due = append(due, st)
+				delete(s.pending, k)
+			} else if st.NotBefore.Before(next) {
+				next = st.NotBefore
+			}
+		}
+		s.mu.Unlock()
+
+		for _, st := range due {
+			d, t, err := s.delivery(st)
+			if err != nil {
+				log.Printf("[SCHED] drop unreadable entry %s: %v", st.Key, err)
+				s.forget(st.Key)
+				continue
+			}
+			log.Printf("[SCHED] due taskId=%s action=%s", t.TaskID, t.Action)
+			s.run(d, t, nil)
+		}
+
+		timer.Reset(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// delivery reconstruit une livraison dont l'ack supprime l'entrée persistée.
+func (s *scheduler) delivery(st scheduledTask) (amqp091.Delivery, Task, error) {
+	var t Task
+	if err := json.Unmarshal(st.Body, &t); err != nil {
+		return amqp091.Delivery{}, t, err
+	}
+	d := amqp091.Delivery{
+		Acknowledger:  scheduleAck{s: s, key: st.Key},
+		ContentType:   st.ContentType,
+		CorrelationId: st.CorrelationID,
+		Headers:       amqp091.Table{},
+		Body:          []byte(st.Body),
+	}
+	return d, t, nil
+}
+
+// scheduleAck: ack / rejet définitif d'une tâche différée = suppression du fichier.
+type scheduleAck struct {
+	s   *scheduler
+	key string
+}
+
+func (a scheduleAck) Ack(uint64, bool) error { a.s.forget(a.key); return nil }
+
+func (a scheduleAck) Nack(_ uint64, _ bool, requeue bool) error {
+	if !requeue {
+		a.s.forget(a.key)
+	}
+	return nil
+}
+
+func (a scheduleAck) Reject(_ uint64, requeue bool) error {
+	if !requeue {
+		a.s.forget(a.key)
+	}
+	return nil
+}
+
+func (s *scheduler) path(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:16])+".json")
+}
+
+// persist écrit l'entrée de façon atomique (fichier temporaire + rename).
+func (s *scheduler) persist(st scheduledTask) error {
+	if s.dir == "" {
+		return nil
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".sched-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer func() { _ = os.Remove(tmpPath) }()
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path(st.Key))
+}
+
+func (s *scheduler) forget(key string) {
+	if s.dir == "" {
+		return
+	}
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[SCHED] remove %s: %v", key, err)
+	}
+}
+
+// load relit les tâches différées d'un process précédent (échéances passées: exécutées de suite).
+func (s *scheduler) load() {
+	if s.dir == "" {
+		return
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		var st scheduledTask
+		if err == nil {
+			err = json.Unmarshal(b, &st)
+		}
+		if err != nil || st.Key == "" {
+			log.Printf("[SCHED] skip unreadable %s: %v", e.Name(), err)
+			continue
+		}
+		s.pending[st.Key] = st
+	}
+	if len(s.pending) > 0 {
+		log.Printf("[SCHED] %d scheduled tasks restored from %s", len(s.pending), s.dir)
+	}
+}
+
+// scheduleKey: taskId, sinon empreinte du corps (une redelivery remplace l'entrée).
+func scheduleKey(t Task, body []byte) string {
+	if t.TaskID != "" {
+		return t.TaskID
+	}
+	h := sha256.Sum256(body)
+	return "body:" + hex.EncodeToString(h[:])
+}
@@ -0,0 +1,76 @@
+package amqp
+
+import "log"
+
+// workerPool borne l'exécution concurrente des tâches: un plafond global, des
+// slots réservés par lane (jamais pris par les autres classes) et, optionnellement,
+// un plafond par classe d'action (ex: "bulk").
+type workerPool struct {
+	shared   chan struct{}
+	reserved map[string]chan struct{}
+	classes  map[string]chan struct{}
+	classOf  func(string) string
+}
+
+func newWorkerPool(opts ConsumerOpts) *workerPool {
+	p := &workerPool{
+		reserved: map[string]chan struct{}{},
+		classes:  map[string]chan struct{}{},
+		classOf:  opts.ClassOf,
+	}
+	shared := opts.Concurrency
+	for class, n := range opts.LaneSlots {
+		if n > 0 {
+			p.reserved[class] = make(chan struct{}, n)
+			shared -= n
+		}
+	}
+	if shared < 1 {
+		log.Printf("[AMQP] laneSlots use all %d workers: 1 shared slot added", opts.Concurrency)
+		shared = 1
+	}
+	p.shared = make(chan struct{}, shared)
+	for class, n := range opts.ClassLimits {
+		if n > 0 {
+			p.classes[class] = make(chan struct{}, n)
+		}
+	}
+	return p
+}
+
+// acquire bloque jusqu'à obtenir un slot pour l'action et renvoie la fonction de libération.
+// Le slot de classe est pris avant le slot global pour ne pas immobiliser un worker
+// global pendant l'attente d'une classe saturée. Une classe avec des slots réservés
+// les utilise en priorité, puis se rabat sur les slots partagés.
+func (p *workerPool) acquire(action string) func() {
+	class := ""
+	if p.classOf != nil {
+		class = p.classOf(action)
+	}
+	classSem := p.classes[class]
+	if classSem != nil {
+		classSem <- struct{}{}
+	}
+
+	slot := p.shared
+	if res := p.reserved[class]; res != nil {
+		select {
+		case res <- struct{}{}:
+			slot = res
+		default:
+			select {
+			case res <- struct{}{}:
+				slot = res
+			case p.shared <- struct{}{}:
+			}
+		}
+	} else {
+		p.shared <- struct{}{}
+	}
+	return func() {
+		<-slot
+		if classSem != nil {
+			<-classSem
+		}
+	}
+}
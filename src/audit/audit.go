@@ -0,0 +1,287 @@
+// Package audit tient un journal local des tâches (JSONL, append-only), chaque
+// enregistrement étant chaîné au précédent par son empreinte SHA-256.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openhvx-agent/redact"
+)
+
+const (
+	filePrefix = "audit-"
+	fileSuffix = ".jsonl"
+)
+
+// EventChainBreak: écrit par Open quand la fin du journal est illisible ou altérée.
+const EventChainBreak = "chain-break"
+
+// ChainBreak décrit une rupture trouvée à l'ouverture (publiée en alerte par l'agent).
+type ChainBreak struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+	Seq    int64  `json:"seq"`  // dernier enregistrement valide: la chaîne reprend après lui
+	Prev   string `json:"prev"` // son empreinte
+}
+
+// OnChainBreak est appelé quand Open reprend la chaîne après une rupture (ex: alerte télémétrie).
+var OnChainBreak func(ChainBreak)
+
+// Record: une ligne du journal. Hash = sha256 du JSON de l'enregistrement sans "hash";
+// Prev = Hash de l'enregistrement précédent (y compris à travers la rotation quotidienne).
+type Record struct {
+	Seq        int64  `json:"seq"`
+	TS         string `json:"ts"`
+	Event      string `json:"event"` // received | started | finished (voir amqp.TaskEvent) | chain-break
+	TaskID     string `json:"taskId,omitempty"`
+	TenantID   string `json:"tenantId,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Attempt    int    `json:"attempt,omitempty"`
+	InputHash  string `json:"inputHash,omitempty"` // sha256 des data après masquage des secrets
+	Status     string `json:"status,omitempty"`    // finished: succeeded | failed | timeout | cancelled | expired | retrying
+	ErrorCode  string `json:"errorCode,omitempty"`
+	DurationMs int64  `json:"durationMs,omitempty"`
+	Detail     string `json:"detail,omitempty"` // chain-break: fichier et raison
+	Prev       string `json:"prev"`
+	Hash       string `json:"hash,omitempty"`
+}
+
+// Log: journal ouvert en écriture (un fichier par jour UTC).
+type Log struct {
+	dir  string
+	mu   sync.Mutex
+	f    *os.File
+	day  string
+	seq  int64
+	prev string
+}
+
+// Open prépare le journal dans dir et reprend la chaîne au dernier enregistrement valide.
+// Des enregistrements illisibles ou altérés en fin de journal ne remettent pas la chaîne
+// à zéro: Open écrit un enregistrement chain-break chaîné au dernier valide et appelle
+// OnChainBreak (-verify-audit continue de signaler la ligne en cause).
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir}
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var brk *ChainBreak
+	for i := len(files) - 1; i >= 0; i-- {
+		last, bad, err := lastGoodRecord(files[i])
+		if err != nil {
+			return nil, err
+		}
+		if bad > 0 && brk == nil {
+			brk = &ChainBreak{File: filepath.Base(files[i]), Reason: fmt.Sprintf("%d unreadable or altered record(s) at end of file", bad)}
+		}
+		if last != nil {
+			l.seq, l.prev = last.Seq, last.Hash
+			break
+		}
+	}
+	if brk != nil {
+		brk.Seq, brk.Prev = l.seq, l.prev
+		log.Printf("[AUDIT] chain break in %s: %s; resuming after seq %d", brk.File, brk.Reason, brk.Seq)
+		if OnChainBreak != nil {
+			OnChainBreak(*brk)
+		}
+		if err := l.Append(Record{Event: EventChainBreak, Detail: brk.File + ": " + brk.Reason}); err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Append complète (seq, ts, prev, hash) et écrit l'enregistrement.
+func (l *Log) Append(r Record) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UTC()
+	if err := l.rotate(now.Format("2006-01-02")); err != nil {
+		return err
+	}
+	r.Seq = l.seq + 1
+	r.TS = now.Format(time.RFC3339Nano)
+	r.Prev = l.prev
+	r.Hash = ""
+	r.Hash = hashRecord(r)
+	line, _ := json.Marshal(r)
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.seq, l.prev = r.Seq, r.Hash
+	return nil
+}
+
+// Close ferme le fichier courant.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+func (l *Log) rotate(day string) error {
+	if l.f != nil && l.day == day {
+		return nil
+	}
+	if l.f != nil {
+		_ = l.f.Close()
+	}
+	path := filepath.Join(l.dir, filePrefix+day+fileSuffix)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		l.f = nil
+		return err
+	}
+	// Ligne interrompue (arrêt brutal): ne pas coller l'enregistrement suivant derrière
+	if b, err := os.ReadFile(path); err == nil && len(b) > 0 && b[len(b)-1] != '\n' {
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			_ = f.Close()
+			l.f = nil
+			return err
+		}
+	}
+	l.f, l.day = f, day
+	return nil
+}
+
+// InputHash: empreinte des données d'une tâche, secrets masqués au préalable.
+func InputHash(data map[string]any) string {
+	b, _ := json.Marshal(data)
+	sum := sha256.Sum256(redact.JSON(b))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashRecord(r Record) string {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify relit tous les fichiers de dir dans l'ordre et contrôle empreintes, chaînage
+// et numérotation. Renvoie le nombre d'enregistrements valides avant la première anomalie.
+func Verify(dir string) (int, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no audit files in %s", dir)
+	}
+	n := 0
+	var seq int64
+	prev := ""
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return n, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		line := 0
+		for sc.Scan() {
+			line++
+			raw := bytes.TrimSpace(sc.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			where := fmt.Sprintf("%s:%d", filepath.Base(path), line)
+			var r Record
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&r); err != nil {
+				f.Close()
+				return n, fmt.Errorf("%s: unreadable record: %w", where, err)
+			}
+			switch {
+			case r.Hash != hashRecord(r):
+				f.Close()
+				return n, fmt.Errorf("%s: hash mismatch (record altered)", where)
+			case r.Prev != prev:
+				f.Close()
+				return n, fmt.Errorf("%s: broken chain (record removed or reordered)", where)
+			case r.Seq != seq+1:
+				f.Close()
+				return n, fmt.Errorf("%s: sequence gap (%d after %d)", where, r.Seq, seq)
+			}
+			seq, prev = r.Seq, r.Hash
+			n++
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func listFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			out = append(out, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(out) // audit-YYYY-MM-DD: ordre lexicographique = ordre chronologique
+	return out, nil
+}
+
+// lastGoodRecord lit le dernier enregistrement valide d'un fichier (nil si aucun) et
+// compte les lignes illisibles ou altérées qui le suivent.
+func lastGoodRecord(path string) (*Record, int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	bad := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := bytes.TrimSpace(lines[i])
+		if len(line) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil || r.Hash == "" || r.Hash != hashRecord(r) {
+			bad++
+			continue
+		}
+		return &r, bad, nil
+	}
+	return nil, bad, nil
+}
@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog écrit n enregistrements dans un nouveau journal et renvoie son fichier.
+func writeLog(t *testing.T, dir string, n int) string {
+	t.Helper()
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := l.Append(Record{Event: "received", TaskID: "t" + string(rune('a'+i)), Action: "vm.power"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := listFiles(dir)
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no audit file: %v", err)
+	}
+	return files[len(files)-1]
+}
+
+func TestVerify(t *testing.T) {
+	cases := []struct {
+		name    string
+		tamper  func(lines []string) []string
+		wantN   int
+		wantErr string
+	}{
+		{"intact", func(l []string) []string { return l }, 3, ""},
+		{"altered field", func(l []string) []string {
+			l[1] = strings.Replace(l[1], `"vm.power"`, `"vm.delete"`, 1)
+			return l
+		}, 1, "hash mismatch"},
+		{"removed record", func(l []string) []string { return append(l[:1], l[2:]...) }, 1, "broken chain"},
+		{"reordered", func(l []string) []string {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}, 1, "broken chain"},
+		{"truncated line", func(l []string) []string {
+			l[2] = l[2][:len(l[2])/2]
+			return l
+		}, 2, "unreadable record"},
+		{"unknown field", func(l []string) []string {
+			l[0] = strings.Replace(l[0], "{", `{"extra":1,`, 1)
+			return l
+		}, 0, "unreadable record"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeLog(t, dir, 3)
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := c.tamper(strings.Split(strings.TrimSpace(string(b)), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			n, err := Verify(dir)
+			if n != c.wantN {
+				t.Errorf("n = %d, want %d", n, c.wantN)
+			}
+			if c.wantErr == "" && err != nil {
+				t.Errorf("err = %v", err)
+			}
+			if c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+				t.Errorf("err = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyEmpty(t *testing.T) {
+	if _, err := Verify(t.TempDir()); err == nil {
+		t.Error("Verify on an empty dir should fail")
+	}
+}
+
+// La chaîne reprend après un redémarrage et à travers la rotation quotidienne.
+func TestOpenResumesChain(t *testing.T) {
+	dir := t.TempDir()
+	path := writeLog(t, dir, 2)
+	if err := os.Rename(path, filepath.Join(dir, filePrefix+"2000-01-01"+fileSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	writeLog(t, dir, 2)
+
+	n, err := Verify(dir)
+	if err != nil || n != 4 {
+		t.Fatalf("Verify = %d, %v; want 4, nil", n, err)
+	}
+	last, bad, err := lastGoodRecord(filepath.Join(dir, filePrefix+"2000-01-01"+fileSuffix))
+	if err != nil || last == nil || last.Seq != 2 || bad != 0 {
+		t.Fatalf("lastGoodRecord = %+v, %d, %v", last, bad, err)
+	}
+}
+
+// Fin de journal corrompue (ligne interrompue): la chaîne reprend au dernier valide,
+// avec un enregistrement chain-break et une alerte, sans repartir de seq 0.
+func TestOpenBrokenChain(t *testing.T) {
+	dir := t.TempDir()
+	path := writeLog(t, dir, 2)
+	good, _, err := lastGoodRecord(path)
+	if err != nil || good == nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"seq":3,"ev`)
+	f.Close()
+
+	var alerts []ChainBreak
+	OnChainBreak = func(b ChainBreak) { alerts = append(alerts, b) }
+	t.Cleanup(func() { OnChainBreak = nil })
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if len(alerts) != 1 || alerts[0].Seq != 2 || alerts[0].Prev != good.Hash {
+		t.Errorf("alerts = %+v, want one break after seq 2", alerts)
+	}
+	brk, bad, err := lastGoodRecord(path)
+	if err != nil || brk == nil || bad != 0 {
+		t.Fatalf("lastGoodRecord = %+v, %d, %v", brk, bad, err)
+	}
+	if brk.Event != EventChainBreak || brk.Seq != 3 || brk.Prev != good.Hash {
+		t.Errorf("last record = %+v, want chain-break seq 3 chained to seq 2", brk)
+	}
+	// La ligne en cause reste signalée par -verify-audit
+	if n, err := Verify(dir); n != 2 || err == nil || !strings.Contains(err.Error(), "unreadable record") {
+		t.Errorf("Verify = %d, %v", n, err)
+	}
+}
+
+func TestInputHashRedacts(t *testing.T) {
+	a := InputHash(map[string]any{"name": "vm", "password": "one"})
+	b := InputHash(map[string]any{"name": "vm", "password": "two"})
+	c := InputHash(map[string]any{"name": "vm2", "password": "one"})
+	if a != b {
+		t.Error("secrets must not change the input hash")
+	}
+	if a == c {
+		t.Error("non-secret fields must change the input hash")
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	if err := l.Append(Record{Event: "received"}); err != nil {
+		t.Error(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Error(err)
+	}
+}
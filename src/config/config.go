@@ -6,12 +6,40 @@ import (
 )
 
 type Config struct {
-	AgentID              string   `json:"agentId"`
-	RabbitMQURL          string   `json:"rabbitmqUrl"`          // ⚠️ clé JSON en camelCase
-	HeartbeatIntervalSec int      `json:"heartbeatIntervalSec"` // ex: 30
-	InventoryIntervalSec int      `json:"inventoryIntervalSec"` // ex: 60
-	Capabilities         []string `json:"capabilities"`         // ex: ["inventory","vm.power"]
-	BasePath             string   `json:"basePath"`             // ex: "C:\\Hyper-V"
+	AgentID                 string                    `json:"agentId"`
+	RabbitMQURL             string                    `json:"rabbitmqUrl"`             // ⚠️ clé JSON en camelCase
+	HeartbeatIntervalSec    int                       `json:"heartbeatIntervalSec"`    // ex: 30
+	InventoryIntervalSec    int                       `json:"inventoryIntervalSec"`    // ex: 60
+	Capabilities            []string                  `json:"capabilities"`            // ex: ["inventory","vm.power"]; vide = toutes les actions du catalogue
+	BasePath                string                    `json:"basePath"`                // ex: "C:\\Hyper-V"
+	ActionTimeoutSec        int                       `json:"actionTimeoutSec"`        // ex: 600 (défaut pour toute action)
+	ActionTimeoutsSec       map[string]int            `json:"actionTimeoutsSec"`       // surcharge du timeoutSec des manifests, ex: {"vm.create":3600}
+	Concurrency             int                       `json:"concurrency"`             // tâches exécutées en parallèle (défaut 4)
+	ClassConcurrency        map[string]int            `json:"classConcurrency"`        // plafond par classe, ex: {"bulk":1}
+	ActionClasses           map[string]string         `json:"actionClasses"`           // surcharge action -> classe (interactive|default|bulk)
+	Lanes                   []string                  `json:"lanes"`                   // classes avec leur propre queue (défaut: aucune, une seule queue), ex: ["interactive","bulk"]
+	LaneSlots               map[string]int            `json:"laneSlots"`               // workers réservés par classe (défaut: aucun), ex: {"interactive":1}
+	Prefetch                int                       `json:"prefetch"`                // messages non-ack par queue (défaut 2 x concurrency)
+	RetryBaseDelaySec       int                       `json:"retryBaseDelaySec"`       // délai avant le 2e essai, doublé ensuite (défaut 5)
+	RetryMaxDelaySec        int                       `json:"retryMaxDelaySec"`        // plafond du délai entre essais (défaut 300)
+	TaskStateRetentionHours int                       `json:"taskStateRetentionHours"` // conservation de l'état dédup par taskId (défaut 72)
+	CancelGraceSec          int                       `json:"cancelGraceSec"`          // délai laissé au script après task.cancel avant kill (défaut 30)
+	PwshHosts               int                       `json:"pwshHosts"`               // workers pwsh persistants (défaut 2, -1 = pwsh -File à chaque tâche)
+	PwshHostMaxJobs         int                       `json:"pwshHostMaxJobs"`         // recyclage d'un worker après N jobs (défaut 200)
+	PwshHostMaxGrowthMB     int                       `json:"pwshHostMaxGrowthMB"`     // recyclage si la mémoire du worker croît de plus de N Mo (défaut 512)
+	ExternalActions         map[string]ExternalAction `json:"externalActions"`         // action -> exécutable externe (au lieu d'un script)
+	IntegrityPublicKeys     []string                  `json:"integrityPublicKeys"`     // clés Ed25519 (base64) du manifest signé; vide = pas de vérification
+	ActionsWatchIntervalSec int                       `json:"actionsWatchIntervalSec"` // rechargement à chaud de powershell/actions (défaut 5, -1 = désactivé)
+	ScriptStdoutMaxKB       int                       `json:"scriptStdoutMaxKB"`       // STDOUT conservé par exécution, début (défaut 4096)
+	ScriptStderrMaxKB       int                       `json:"scriptStderrMaxKB"`       // STDERR conservé par exécution, fin (défaut 16)
+	RedactKeys              []string                  `json:"redactKeys"`              // champs secrets masqués en plus des défauts (password, ticket, ...)
+	ScriptEnvAllow          []string                  `json:"scriptEnvAllow"`          // variables de l'agent transmises aux scripts en plus de la liste blanche
+}
+
+// ExternalAction: action déléguée à un exécutable, qui reçoit { action, data } sur STDIN.
+type ExternalAction struct {
+	Path string   `json:"path"` // ex: "C:\\openhvx\\bin\\iso-tool.exe"
+	Args []string `json:"args"`
 }
 
 func Load(path string) (*Config, error) {
@@ -30,8 +58,47 @@ func Load(path string) (*Config, error) {
 	if cfg.InventoryIntervalSec <= 0 {
 		cfg.InventoryIntervalSec = 60
 	}
-	if len(cfg.Capabilities) == 0 {
-		cfg.Capabilities = []string{"inventory", "vm.power"}
+	if cfg.ActionTimeoutSec <= 0 {
+		cfg.ActionTimeoutSec = 600
+	}
+	if cfg.ActionTimeoutsSec == nil {
+		cfg.ActionTimeoutsSec = map[string]int{}
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+	if cfg.RetryBaseDelaySec <= 0 {
+		cfg.RetryBaseDelaySec = 5
+	}
+	if cfg.RetryMaxDelaySec < cfg.RetryBaseDelaySec {
+		cfg.RetryMaxDelaySec = 300
+	}
+	if cfg.CancelGraceSec <= 0 {
+		cfg.CancelGraceSec = 30
+	}
+	if cfg.TaskStateRetentionHours <= 0 {
+		cfg.TaskStateRetentionHours = 72
+	}
+	if cfg.PwshHosts == 0 {
+		cfg.PwshHosts = 2
+	}
+	if cfg.ScriptStdoutMaxKB <= 0 {
+		cfg.ScriptStdoutMaxKB = 4096
+	}
+	if cfg.ScriptStderrMaxKB <= 0 {
+		cfg.ScriptStderrMaxKB = 16
+	}
+	if cfg.ActionsWatchIntervalSec == 0 {
+		cfg.ActionsWatchIntervalSec = 5
+	}
+	if cfg.PwshHostMaxJobs <= 0 {
+		cfg.PwshHostMaxJobs = 200
+	}
+	if cfg.PwshHostMaxGrowthMB <= 0 {
+		cfg.PwshHostMaxGrowthMB = 512
+	}
+	if cfg.ClassConcurrency == nil {
+		cfg.ClassConcurrency = map[string]int{"bulk": 2}
 	}
 	return &cfg, nil
 }
@@ -19,6 +19,7 @@ type DataDirs struct {
 	ISOs        string
 	Checkpoints string
 	Logs        string
+	State       string // état interne de l'agent (dédup des tâches, ...)
 	Trash       string
 }
 
@@ -40,10 +41,11 @@ func EnsureDataDirs(basePath string) (DataDirs, error) {
 		ISOs:        filepath.Join(root, "ISOs"),
 		Checkpoints: filepath.Join(root, "Checkpoints"),
 		Logs:        filepath.Join(root, "Logs"),
+		State:       filepath.Join(root, "State"),
 		Trash:       filepath.Join(root, "_trash"),
 	}
 
-	for _, p := range []string{d.Root, d.VMS, d.VHD, d.Images, d.ISOs, d.Checkpoints, d.Logs, d.Trash} {
+	for _, p := range []string{d.Root, d.VMS, d.VHD, d.Images, d.ISOs, d.Checkpoints, d.Logs, d.State, d.Trash} {
 		if err := os.MkdirAll(p, 0o755); err != nil {
 			return DataDirs{}, fmt.Errorf("mkdir %s: %w", p, err)
 		}
@@ -58,7 +60,7 @@ func writeGuards(d DataDirs) error {
 			"Any destructive operation must move targets into '_trash'.\n",
 	)
 	var firstErr error
-	for _, dir := range []string{d.Root, d.VMS, d.VHD, d.Images, d.ISOs, d.Checkpoints, d.Logs, d.Trash} {
+	for _, dir := range []string{d.Root, d.VMS, d.VHD, d.Images, d.ISOs, d.Checkpoints, d.Logs, d.State, d.Trash} {
 		fp := filepath.Join(dir, "DO-NOT-DELETE.txt")
 		if _, err := os.Stat(fp); err == nil {
 			continue
@@ -114,6 +116,7 @@ func IsProtectedPath(p string, d DataDirs) bool {
 		filepath.Clean(d.ISOs):        {},
 		filepath.Clean(d.Checkpoints): {},
 		filepath.Clean(d.Logs):        {},
+		filepath.Clean(d.State):       {},
 		filepath.Clean(d.Trash):       {},
 	}
 	_, ok := protect[p]
@@ -442,6 +445,7 @@ func (d DataDirs) DebugString() string {
 		" ISOs=" + d.ISOs +
 		" Checkpoints=" + d.Checkpoints +
 		" Logs=" + d.Logs +
+		" State=" + d.State +
 		" Trash=" + d.Trash
 }
 
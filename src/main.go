@@ -6,18 +6,23 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
 	"openhvx-agent/amqp"
+	"openhvx-agent/audit"
 	"openhvx-agent/config"
 	"openhvx-agent/datadirs"
 	"openhvx-agent/powershell"
+	"openhvx-agent/redact"
 	"openhvx-agent/tasks"
+	"openhvx-agent/taskstore"
 )
 
 type actionResp struct {
@@ -41,18 +46,198 @@ func buildDatastoresParam(d datadirs.DataDirs) []map[string]string {
 	}
 }
 
+// auditTask convertit les étapes du consumer en enregistrements d'audit (nil si pas de journal).
+func auditTask(l *audit.Log) func(amqp.TaskEvent) {
+	if l == nil {
+		return nil
+	}
+	return func(ev amqp.TaskEvent) {
+		r := audit.Record{
+			Event:      ev.Stage,
+			TaskID:     ev.Task.TaskID,
+			TenantID:   ev.Task.TenantID,
+			Action:     ev.Task.Action,
+			Attempt:    ev.Task.Attempt,
+			Status:     string(ev.Status),
+			ErrorCode:  string(ev.ErrorCode),
+			DurationMs: ev.Duration.Milliseconds(),
+		}
+		if ev.Stage == amqp.StageReceived {
+			r.InputHash = audit.InputHash(ev.Task.Data)
+		}
+		if err := l.Append(r); err != nil {
+			log.Printf("[AUDIT] append error: %v", err)
+		}
+	}
+}
+
+// setupRedaction: champs secrets masqués dans les logs / résultats côté Go et dans les
+// dumps de debug côté scripts (env hérité par pwsh).
+func setupRedaction(cfg *config.Config) {
+	redact.SetKeys(cfg.RedactKeys)
+	_ = os.Setenv(redact.KeysEnv, strings.Join(redact.Keys(), ","))
+}
+
+// setupActions charge les manifests et applique la config des actions (intégrité, délais,
+// classes, capabilities, limites de sortie, environnement, actions externes). Partagé avec le runner local.
+func setupActions(cfg *config.Config, dirs datadirs.DataDirs) error {
+	// Manifests des scripts d'action: sans manifest, une action n'est pas exécutée
+	catalog, err := powershell.LoadCatalog()
+	if err != nil {
+		log.Printf("warn: action manifests: %v", err)
+	} else {
+		log.Printf("[PS] %d action manifests loaded from %s", len(catalog.Actions), catalog.Dir)
+	}
+	if err := powershell.SetIntegrityKeys(cfg.IntegrityPublicKeys); err != nil {
+		return fmt.Errorf("integrity keys: %w", err)
+	}
+	if !powershell.IntegrityEnabled() {
+		log.Printf("warn: no integrityPublicKeys configured; action scripts are not verified")
+	}
+	tasks.SetActionTimeouts(cfg.ActionTimeoutSec, cfg.ActionTimeoutsSec)
+	tasks.SetActionClasses(cfg.ActionClasses)
+	tasks.SetCancelGrace(cfg.CancelGraceSec)
+	tasks.SetCapabilities(cfg.Capabilities)
+	if len(cfg.Capabilities) == 0 {
+		log.Printf("warn: no capabilities configured; every action in the catalog is enabled")
+	}
+	powershell.SetOutputLimits(cfg.ScriptStdoutMaxKB<<10, cfg.ScriptStderrMaxKB<<10)
+	// Environnement explicite des scripts, dossier de travail fixe sous State/work
+	workDir := ""
+	if dirs.State != "" {
+		workDir = filepath.Join(dirs.State, "work")
+	}
+	if err := powershell.SetScriptEnv(cfg.ScriptEnvAllow, workDir); err != nil {
+		return fmt.Errorf("script work dir: %w", err)
+	}
+	for action, ext := range cfg.ExternalActions {
+		tasks.Register(action, tasks.BinaryExecutor{Path: ext.Path, Args: ext.Args})
+	}
+	return nil
+}
+
+// runLocalAction exécute une action comme une tâche reçue (capability, schéma, __ctx,
+// executor, délai) et affiche l'enveloppe de résultat qui serait publiée, sans AMQP.
+// Progression et logs sur STDERR; code de sortie 0 si ok, 1 sinon, 2 si usage invalide.
+func runLocalAction(cfgPath string, args []string) int {
+	fs := flag.NewFlagSet("action", flag.ContinueOnError)
+	dataPath := fs.String("data", "", "Fichier JSON des données de la tâche (- pour STDIN)")
+	tenant := fs.String("tenant", "", "tenantId de la tâche")
+	dry := fs.Bool("dry-run", false, "Tâche dryRun (plan seulement)")
+	name := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if name == "" {
+		name = fs.Arg(0)
+	}
+	if name == "" {
+		fmt.Fprintln(os.Stderr, "usage: openhvx-agent [-config config.json] action <name> [--data file.json] [--tenant T] [--dry-run]")
+		return 2
+	}
+
+	data := map[string]any{}
+	if *dataPath != "" {
+		var raw []byte
+		var err error
+		if *dataPath == "-" {
+			raw, err = io.ReadAll(os.Stdin)
+		} else {
+			raw, err = os.ReadFile(*dataPath)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "data error:", err)
+			return 1
+		}
+		if err := json.Unmarshal(raw, &data); err != nil {
+			fmt.Fprintln(os.Stderr, "data error: expected a JSON object:", err)
+			return 1
+		}
+	}
+
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config error:", err)
+		return 1
+	}
+	setupRedaction(cfg)
+	var dirs datadirs.DataDirs
+	if cfg.BasePath != "" {
+		if dirs, err = datadirs.EnsureDataDirs(cfg.BasePath); err != nil {
+			fmt.Fprintln(os.Stderr, "ensure data dirs:", err)
+			return 1
+		}
+	}
+	tasks.SetRuntimeContext(cfg.AgentID, cfg.BasePath, dirs)
+	if err := setupActions(cfg, dirs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Pas d'AMQP: progression dans le log, pas de pool pwsh (pwsh -File par tâche)
+	tasks.PublishProgress = func(t amqp.Task, seq int, p powershell.Progress) error {
+		log.Printf("[TASK] progress #%d %.0f%% %s: %s", seq, p.Percent, p.Step, p.Message)
+		return nil
+	}
+
+	t := amqp.Task{
+		TaskID:   fmt.Sprintf("local-%d", time.Now().UnixNano()),
+		AgentID:  cfg.AgentID,
+		TenantID: *tenant,
+		Action:   name,
+		Data:     data,
+		DryRun:   *dry,
+	}
+	// CTRL+C = task.cancel (fichier d'annulation puis kill après le délai de grâce)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		<-stop
+		tasks.CancelTask(t.TaskID, "interrupted by operator")
+	}()
+
+	started := time.Now()
+	out, hErr := tasks.HandleTask(t)
+	res := amqp.BuildTaskResult(cfg.AgentID, t, started, out, hErr)
+	_, _ = os.Stdout.Write(append(res.Encode(), '\n'))
+	if !res.OK {
+		return 1
+	}
+	return 0
+}
+
 func main() {
 	// Flags
 	cfgPath := flag.String("config", "config.json", "Chemin du fichier de configuration")
 	dryRun := flag.Bool("dry-run", false, "Mode sec: pas d'AMQP, affiche seulement un JSON et quitte")
-	module := flag.String("modules", "inventory", "Dry-run module: inventory | heartbeat")
+	module := flag.String("modules", "inventory", "Dry-run module: inventory | heartbeat | schemas")
+	verifyAudit := flag.String("verify-audit", "", "Vérifie la chaîne du journal d'audit (dossier, ex: D:\\DATA\\openhvx\\Logs\\audit) puis quitte")
 	flag.Parse()
 
-	// Logs sur stderr
-	log.SetOutput(os.Stderr)
+	// Logs sur stderr, secrets masqués (mots de passe, tickets, identifiants d'URL, ...)
+	log.SetOutput(redact.Writer(os.Stderr))
 	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
 	log.SetPrefix("[agent] ")
 
+	// === VÉRIFICATION HORS LIGNE DU JOURNAL D'AUDIT ===
+	if *verifyAudit != "" {
+		n, err := audit.Verify(*verifyAudit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "audit chain INVALID after %d records: %v\n", n, err)
+			os.Exit(1)
+		}
+		fmt.Printf("audit chain OK (%d records)\n", n)
+		os.Exit(0)
+	}
+
+	// === RUNNER LOCAL: action <name> --data file.json [--tenant T] ===
+	if flag.Arg(0) == "action" {
+		os.Exit(runLocalAction(*cfgPath, flag.Args()[1:]))
+	}
+
 	// === DRY-RUN ===
 	if *dryRun {
 		switch strings.ToLower(*module) {
@@ -105,19 +290,37 @@ func main() {
 				fmt.Fprintln(os.Stderr, "config error:", err)
 				os.Exit(1)
 			}
+			tasks.SetCapabilities(cfg.Capabilities)
 			hb := map[string]any{
 				"v":            1,
 				"agentId":      cfg.AgentID,
 				"ts":           time.Now().UTC().Format(time.RFC3339),
-				"version":      "0.1.0",
-				"capabilities": cfg.Capabilities, // depuis la config
+				"version":      amqp.AgentVersion,
+				"capabilities": tasks.Capabilities(), // manifests ∩ config
+				"actions":      tasks.ActionVersions(),
 			}
 			out, _ := json.Marshal(hb)
 			_, _ = os.Stdout.Write(out)
 			os.Exit(0)
 
+		case "schemas":
+			cfg, err := config.Load(*cfgPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "config error:", err)
+				os.Exit(1)
+			}
+			tasks.SetCapabilities(cfg.Capabilities)
+			schemas, err := tasks.ActionSchemas()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "schemas load error:", err)
+				os.Exit(1)
+			}
+			out, _ := json.Marshal(schemas)
+			_, _ = os.Stdout.Write(out)
+			os.Exit(0)
+
 		default:
-			fmt.Fprintln(os.Stderr, "unknown dry-run module (use: inventory | heartbeat)")
+			fmt.Fprintln(os.Stderr, "unknown dry-run module (use: inventory | heartbeat | schemas)")
 			os.Exit(2)
 		}
 	}
@@ -127,6 +330,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("config load failed (%s): %v", *cfgPath, err)
 	}
+	setupRedaction(cfg)
 
 	// 1) Préparer l’arbo gérée + exposer le contexte pour PowerShell (__ctx)
 	var dirs datadirs.DataDirs
@@ -140,15 +344,46 @@ func main() {
 		log.Printf("no basePath configured; datastores will be empty in inventory")
 	}
 	tasks.SetRuntimeContext(cfg.AgentID, cfg.BasePath, dirs)
+
+	if err := setupActions(cfg, dirs); err != nil {
+		log.Fatalf("%v", err)
+	}
 	dsParam := buildDatastoresParam(dirs)
 
+	// Workers pwsh persistants (sinon: pwsh -File à chaque tâche)
+	if err := powershell.StartHostPool(powershell.HostPoolOpts{
+		Size:        cfg.PwshHosts,
+		MaxJobs:     cfg.PwshHostMaxJobs,
+		MaxGrowthMB: cfg.PwshHostMaxGrowthMB,
+	}); err != nil {
+		log.Printf("warn: powershell host pool disabled: %v", err)
+	}
+	defer powershell.StopHostPool()
+
 	// 2) AMQP
 	if err := amqp.InitPublisher(cfg.RabbitMQURL); err != nil {
 		log.Fatalf("amqp init failed: %v", err)
 	}
 	defer amqp.ClosePublisher()
 
+	// Intégrité des scripts: chaque violation (démarrage ou avant exécution) -> alert.<agentId>
+	powershell.OnIntegrityViolation = func(v powershell.IntegrityViolation) {
+		go func() {
+			if err := amqp.PublishAlert(cfg.AgentID, "integrity", "critical", v); err != nil {
+				log.Println("alert publish error:", err)
+			}
+		}()
+	}
+	if violations, err := powershell.VerifyIntegrity(); err != nil {
+		log.Printf("warn: integrity check: %v", err)
+	} else if len(violations) > 0 {
+		log.Printf("[PS] integrity: %d violation(s) at startup; affected actions are blocked", len(violations))
+	}
+
 	amqp.AfterResult = func(t amqp.Task) {
+		if t.DryRun {
+			return // rien n'a changé
+		}
 		tasks.KickLightRefresh(context.Background(), tasks.LightCtx{
 			AgentID:    cfg.AgentID,
 			BasePath:   cfg.BasePath,
@@ -161,18 +396,21 @@ func main() {
 	hbEvery := time.Duration(cfg.HeartbeatIntervalSec) * time.Second
 	invEvery := time.Duration(cfg.InventoryIntervalSec) * time.Second
 
-	// Heartbeat périodique
+	// Heartbeat périodique (et immédiat après un rechargement des actions)
+	host, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("Not able to retrieve hostname: %v", err)
+	}
+	sendHeartbeat := func() {
+		if err := amqp.PublishHeartbeat(cfg.AgentID, host, tasks.Capabilities(), tasks.ActionVersions()); err != nil {
+			log.Println("heartbeat error:", err)
+		}
+	}
 	go func() {
 		t := time.NewTicker(hbEvery)
 		defer t.Stop()
-		host, err := os.Hostname()
-		if err != nil {
-			log.Fatalf("Not able to retrieve hostname: %v", err)
-		}
 		for range t.C {
-			if err := amqp.PublishHeartbeat(cfg.AgentID, host, cfg.Capabilities); err != nil {
-				log.Println("heartbeat error:", err)
-			}
+			sendHeartbeat()
 		}
 	}()
 
@@ -184,10 +422,12 @@ func main() {
 			// Lister les images dispo (peut être vide)
 
 			// Passe basePath + datastores + images au script
-			raw, err := powershell.RunActionScript("inventory.refresh", map[string]any{
+			ctx, cancel := context.WithTimeout(context.Background(), tasks.ActionTimeout("inventory.refresh"))
+			raw, err := powershell.RunActionScriptContext(ctx, "inventory.refresh", map[string]any{
 				"basePath":   cfg.BasePath,
 				"datastores": dsParam,
 			})
+			cancel()
 			if err != nil {
 				log.Println("inventory collect error:", err)
 				continue
@@ -209,12 +449,81 @@ func main() {
 	}()
 
 	// 4) Consumer des tâches -> tasks.HandleTask (injecte __ctx pour les scripts)
-	if err := amqp.StartTaskConsumer(cfg.AgentID, tasks.HandleTask); err != nil {
+	//    Dédup par taskId persistée sous State/tasks (si basePath configuré)
+	var store *taskstore.Store
+	if dirs.State != "" {
+		store, err = taskstore.Open(filepath.Join(dirs.State, "tasks"), time.Duration(cfg.TaskStateRetentionHours)*time.Hour)
+		if err != nil {
+			log.Printf("warn: task state store disabled: %v", err)
+		}
+	}
+	// Tâches notBefore retenues sous State/scheduled (survivent au redémarrage)
+	schedDir := ""
+	if dirs.State != "" {
+		schedDir = filepath.Join(dirs.State, "scheduled")
+	}
+	// Journal d'audit chaîné sous Logs/audit (si basePath configuré)
+	var auditLog *audit.Log
+	if dirs.Logs != "" {
+		audit.OnChainBreak = func(b audit.ChainBreak) {
+			go func() {
+				if err := amqp.PublishAlert(cfg.AgentID, "audit", "critical", b); err != nil {
+					log.Println("alert publish error:", err)
+				}
+			}()
+		}
+		auditLog, err = audit.Open(filepath.Join(dirs.Logs, "audit"))
+		if err != nil {
+			log.Printf("warn: audit log disabled: %v", err)
+		}
+		defer auditLog.Close()
+	}
+	if err := amqp.StartTaskConsumerWithOpts(amqp.ConsumerOpts{
+		AgentID:     cfg.AgentID,
+		Handle:      tasks.HandleTask,
+		Concurrency: cfg.Concurrency,
+		ClassLimits: cfg.ClassConcurrency,
+		ClassOf:     tasks.ActionClass,
+		Lanes:       cfg.Lanes,
+		LaneSlots:   cfg.LaneSlots,
+		Prefetch:    cfg.Prefetch,
+		ReserveLock: tasks.ReserveTaskLock,
+		RetryBase:   time.Duration(cfg.RetryBaseDelaySec) * time.Second,
+		RetryMax:    time.Duration(cfg.RetryMaxDelaySec) * time.Second,
+		Store:       store,
+		Cancel:      tasks.CancelTask,
+		OnEvent:     auditTask(auditLog),
+		ScheduleDir: schedDir,
+	}); err != nil {
 		log.Fatalf("start consumer failed: %v", err)
 	}
 
 	log.Printf("started | agentId=%s rmq=%s", cfg.AgentID, cfg.RabbitMQURL)
 
+	// Schémas d'entrée des actions -> controller (validation avant envoi)
+	publishSchemas := func() {
+		if schemas, err := tasks.ActionSchemas(); err != nil {
+			log.Println("schemas load error:", err)
+		} else if err := amqp.PublishActionSchemas(cfg.AgentID, schemas); err != nil {
+			log.Println("schemas publish error:", err)
+		}
+	}
+	publishSchemas()
+
+	// Rechargement à chaud de powershell/actions: sans redémarrer le consumer,
+	// les tâches en cours terminent sur le manifest / script résolus à leur démarrage
+	powershell.WatchCatalog(time.Duration(cfg.ActionsWatchIntervalSec)*time.Second, func(_ *powershell.Catalog, change powershell.CatalogChange) {
+		if change.Empty() {
+			return
+		}
+		if _, err := powershell.VerifyIntegrity(); err != nil {
+			log.Printf("warn: integrity check: %v", err)
+		}
+		sendHeartbeat()
+		publishSchemas()
+	})
+	defer powershell.StopWatchCatalog()
+
 	// Arrêt propre (CTRL+C / SIGTERM)
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
@@ -6,11 +6,44 @@ if ([string]::IsNullOrWhiteSpace($raw)) {
 }
 $task = $raw | ConvertFrom-Json
 
+# Manifest obligatoire: copier _template.manifest.json en <action>.manifest.json (inputMode "stdin" ici).
+# Validation d'entrée: décrire $task.data dans le schéma référencé par "inputSchema";
+# l'agent la valide en Go avant de lancer ce script.
+
+# Progression (optionnelle): une ligne NDJSON préfixée sur STDERR, publiée par l'agent
+# sur results / task.<id>.progress. Ne jamais écrire de progression sur STDOUT.
+function Write-TaskProgress {
+  param([int]$Percent, [string]$Step, [string]$Message)
+  $rec = [ordered]@{ percent = $Percent; step = $Step; message = $Message } | ConvertTo-Json -Compress
+  try { [Console]::Error.WriteLine("##openhvx:progress $rec") } catch {}
+}
+
+# Annulation (task.cancel): l'agent crée $env:OPENHVX_CANCEL_FILE puis tue le process après
+# le délai de grâce. Vérifier entre les étapes pour annuler proprement (rollback).
+function Assert-NotCancelled {
+  if ($env:OPENHVX_CANCEL_FILE -and (Test-Path -LiteralPath $env:OPENHVX_CANCEL_FILE)) { throw "cancelled by controller" }
+}
+
+# Dry-run: si $task.data.__ctx.dryRun, décrire les changements prévus sans rien modifier
+# (déclarer "supportsDryRun": true dans le manifest, sinon l'agent refuse la tâche).
+
+# Erreur typée: le code (INVALID_INPUT, NOT_FOUND, CONFLICT, BACKEND_UNAVAILABLE, ...) est
+# publié par l'agent dans errorCode avec retryable. Sans code: ACTION_FAILED, non rejoué
+# sauf si la sortie porte "retryable": $true.
+function Throw-TaskError {
+  param([Parameter(Mandatory = $true)][string]$Code, [Parameter(Mandatory = $true)][string]$Message)
+  $ex = [System.Exception]::new($Message); $ex.Data['code'] = $Code; throw $ex
+}
+
 try {
   # $task.action, $task.data
+  if (-not $task.data) { Throw-TaskError INVALID_INPUT "missing data" }
+  Assert-NotCancelled
+  Write-TaskProgress -Percent 50 -Step "work" -Message "doing the thing"
   $result = @{ note = "implement me" }
   @{ ok = $true; result = $result } | ConvertTo-Json -Depth 8; exit 0
 }
 catch {
-  @{ ok = $false; error = $_.Exception.Message } | ConvertTo-Json; exit 1
-}
\ No newline at end of file
+  # "retryable" (bool) est optionnel et remplace la valeur par défaut du code
+  @{ ok = $false; error = $_.Exception.Message; code = $_.Exception.Data['code'] } | ConvertTo-Json; exit 1
+}
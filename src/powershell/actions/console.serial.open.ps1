@@ -5,6 +5,11 @@ param(
     [string]$InputJson
 )
 
+function Throw-TaskError {
+    param([Parameter(Mandatory = $true)][string]$Code, [Parameter(Mandatory = $true)][string]$Message)
+    $ex = [System.Exception]::new($Message); $ex.Data['code'] = $Code; throw $ex
+}
+
 # --- Silent runtime ---
 $ErrorActionPreference = 'Stop'
 $ProgressPreference = 'SilentlyContinue'
@@ -16,8 +21,8 @@ function Read-TaskInput {
     param([string]$Inline)
     if ($Inline) { try { return ($Inline | ConvertFrom-Json -ErrorAction Stop) } catch {} }
     $raw = [Console]::In.ReadToEnd()
-    if ([string]::IsNullOrWhiteSpace($raw)) { throw "No JSON input (use -InputJson or pipe JSON to STDIN)" }
-    try { return ($raw | ConvertFrom-Json -ErrorAction Stop) } catch { throw "Invalid JSON input" }
+    if ([string]::IsNullOrWhiteSpace($raw)) { Throw-TaskError INVALID_INPUT "No JSON input (use -InputJson or pipe JSON to STDIN)" }
+    try { return ($raw | ConvertFrom-Json -ErrorAction Stop) } catch { Throw-TaskError INVALID_INPUT "Invalid JSON input" }
 }
 
 function Get-VmGuidFrom($payload) {
@@ -27,11 +32,11 @@ function Get-VmGuidFrom($payload) {
 }
 
 function Get-Com1PipePath([string]$vmGuid) {
-    try { $vm = Get-VM -Id $vmGuid -ErrorAction Stop } catch { throw "VM not by GUID Hyper-V: $vmGuid" }
+    try { $vm = Get-VM -Id $vmGuid -ErrorAction Stop } catch { Throw-TaskError NOT_FOUND "VM not by GUID Hyper-V: $vmGuid" }
     try { $com = Get-VMComPort -VMName $vm.Name -Number 1 -ErrorAction Stop } catch { throw "Impossible to read COM1 of '$($vm.Name)'" }
     $path = [string]$com.Path
-    if (-not $path) { throw "no COM1 configured. Configure a Named Pipe on '$($vm.Name)'." }
-    if ($path -notmatch '^\\\\\.\\pipe\\') { throw "COM1 n'est pas un Named Pipe (Path=$path)." }
+    if (-not $path) { Throw-TaskError CONFLICT "no COM1 configured. Configure a Named Pipe on '$($vm.Name)'." }
+    if ($path -notmatch '^\\\\\.\\pipe\\') { Throw-TaskError CONFLICT "COM1 n'est pas un Named Pipe (Path=$path)." }
     return $path
 }
 
@@ -48,12 +53,12 @@ try {
     $ttl = [int]   ($d.ttlSeconds | ForEach-Object { $_ })
     if (-not $ttl -or $ttl -le 0) { $ttl = 900 }  # défaut 15 min
 
-    if (-not $wsUrl) { throw "Missing agentWsUrl in task payload" }
-    if (-not $tunnelId) { throw "Missing tunnelId in task payload" }
+    if (-not $wsUrl) { Throw-TaskError INVALID_INPUT "Missing agentWsUrl in task payload" }
+    if (-not $tunnelId) { Throw-TaskError INVALID_INPUT "Missing tunnelId in task payload" }
 
     # 3) Resolve GUID VM (Hyper-V) then COM1
     $vmGuid = Get-VmGuidFrom $d
-    if (-not $vmGuid) { throw "Missing VM GUID (data.id or data.target.refId)" }
+    if (-not $vmGuid) { Throw-TaskError INVALID_INPUT "Missing VM GUID (data.id or data.target.refId)" }
 
     $pipePath = Get-Com1PipePath $vmGuid
     $pipeName = Extract-PipeName $pipePath
@@ -144,18 +149,19 @@ try {
 }
 catch {
     $err = $_.Exception.Message
+    $code = $_.Exception.Data['code']
     try {
         $hint = $null
         if ($pipeName) {
             $pipeExists = Test-Path "\\.\pipe\$pipeName"
             $hint = if ($pipeExists) { "Named pipe exist; please verify rights/instances." } else { "Named pipe doesnt exist." }
         }
-        $payload = [pscustomobject]@{ ok = $false; error = $err }
+        $payload = [pscustomobject]@{ ok = $false; error = $err; code = $code }
         if ($hint) { $payload | Add-Member -NotePropertyName hint -NotePropertyValue $hint }
         $payload | ConvertTo-Json -Compress
     }
     catch {
-        [pscustomobject]@{ ok = $false; error = $err } | ConvertTo-Json -Compress
+        [pscustomobject]@{ ok = $false; error = $err; code = $code } | ConvertTo-Json -Compress
     }
     exit 1
 }
@@ -10,6 +10,11 @@ param(
     [string]$InputJson
 )
 
+function Throw-TaskError {
+    param([Parameter(Mandatory = $true)][string]$Code, [Parameter(Mandatory = $true)][string]$Message)
+    $ex = [System.Exception]::new($Message); $ex.Data['code'] = $Code; throw $ex
+}
+
 # --- IMPORTANT: no useless STDOUT ---
 $ErrorActionPreference = 'Stop'
 $ProgressPreference = 'SilentlyContinue'
@@ -65,8 +70,8 @@ function Read-TaskInput {
     param([string]$Inline)
     if ($Inline) { try { return ($Inline | ConvertFrom-Json -ErrorAction Stop) } catch {} }
     $raw = [Console]::In.ReadToEnd()
-    if ([string]::IsNullOrWhiteSpace($raw)) { throw "No JSON input (use -InputJson or pipe JSON to STDIN)" }
-    try { return ($raw | ConvertFrom-Json -ErrorAction Stop) } catch { throw "Invalid JSON input" }
+    if ([string]::IsNullOrWhiteSpace($raw)) { Throw-TaskError INVALID_INPUT "No JSON input (use -InputJson or pipe JSON to STDIN)" }
+    try { return ($raw | ConvertFrom-Json -ErrorAction Stop) } catch { Throw-TaskError INVALID_INPUT "Invalid JSON input" }
 }
 
 # ---------- Helpers debug ----------
@@ -92,6 +97,21 @@ function Write-DebugLog {
     }
 }
 
+# ---------- Helpers progress (canal STDERR lu par l'agent -> results / task.<id>.progress) ----------
+function Write-TaskProgress {
+    param([int]$Percent, [string]$Step, [string]$Message)
+    $rec = [ordered]@{ percent = $Percent; step = $Step; message = $Message } | ConvertTo-Json -Compress
+    try { [Console]::Error.WriteLine("##openhvx:progress $rec") } catch {}
+}
+
+# ---------- Helpers annulation (fichier témoin créé par l'agent sur task.cancel) ----------
+# Appelé entre les étapes: lève une erreur pour passer par le rollback du catch principal.
+function Assert-NotCancelled {
+    if ($env:OPENHVX_CANCEL_FILE -and (Test-Path -LiteralPath $env:OPENHVX_CANCEL_FILE)) {
+        Throw-TaskError CANCELLED "cancelled by controller"
+    }
+}
+
 # ---------- Helpers size ----------
 function Resolve-SizeBytes {
     param([Parameter(Mandatory = $true)][object]$InputValue)
@@ -114,7 +134,7 @@ function Resolve-SizeBytes {
             'TB' { return [int64]($num * 1TB) }
         }
     }
-    throw "invalid size: '$InputValue'"
+    Throw-TaskError INVALID_INPUT "invalid size: '$InputValue'"
 }
 function Align-VMBytes {
     param([int64]$Bytes)
@@ -192,7 +212,7 @@ function Ensure-IscsiTargetConnected {
         $target = $targets | Where-Object { $_.NodeAddress -eq $Iqn }
         if (-not $target) { Start-Sleep -Seconds 1 }
     }
-    if (-not $target) { throw "iSCSI target not found: $Iqn" }
+    if (-not $target) { Throw-TaskError NOT_FOUND "iSCSI target not found: $Iqn" }
 
     if (-not $target.IsConnected) {
         for ($j = 0; $j -lt 3; $j++) {
@@ -440,12 +460,12 @@ try {
     Write-DebugLog -Message "payload received" -Ctx $ctxEarly
     $d = if ($task.PSObject.Properties.Name -contains 'data' -and $task.data) { $task.data } else { $task }
 
-    if ([string]::IsNullOrWhiteSpace($d.name)) { throw "missing 'name'" }
+    if ([string]::IsNullOrWhiteSpace($d.name)) { Throw-TaskError INVALID_INPUT "missing 'name'" }
     $Name = $d.name.Trim()
     $Generation = if ($d.generation) { [int]$d.generation } else { 2 }
-    if ($Generation -notin 1, 2) { throw "invalid 'generation' (must be 1 or 2)" }
+    if ($Generation -notin 1, 2) { Throw-TaskError INVALID_INPUT "invalid 'generation' (must be 1 or 2)" }
 
-    if (-not $d.ram) { throw "missing 'ram'" }
+    if (-not $d.ram) { Throw-TaskError INVALID_INPUT "missing 'ram'" }
     $MemoryStartupBytes = Align-VMBytes (Resolve-SizeBytes $d.ram)
 
     $CPU = if ($d.cpu) { [int]$d.cpu } else { $null }
@@ -461,6 +481,8 @@ try {
 
     $ctx = $d.__ctx
     Write-DebugLog -Message "vm.create start name=$Name" -Ctx $ctx
+    Assert-NotCancelled
+    Write-TaskProgress -Percent 5 -Step "validate" -Message "input validated for '$Name'"
     $tenantId = $null; $root = $null; $vmsRoot = $null; $vhdRoot = $null; $isosRoot = $null
     if ($ctx) {
         $tenantId = $ctx.tenantId
@@ -515,11 +537,11 @@ try {
     $BaseVhdx = $null
     if (-not $UseIscsi) {
         if (-not $d.imagePath) {
-            if ($DiskId) { throw "missing 'iqn' for diskId '$DiskId' (or provide imagePath)" }
-            throw "missing 'iqn' or 'imagePath'"
+            if ($DiskId) { Throw-TaskError INVALID_INPUT "missing 'iqn' for diskId '$DiskId' (or provide imagePath)" }
+            Throw-TaskError INVALID_INPUT "missing 'iqn' or 'imagePath'"
         }
         $BaseVhdx = $d.imagePath
-        if (-not (Test-Path -LiteralPath $BaseVhdx)) { throw "base image not found: $BaseVhdx" }
+        if (-not (Test-Path -LiteralPath $BaseVhdx)) { Throw-TaskError NOT_FOUND "base image not found: $BaseVhdx" }
     }
     elseif ($d.imagePath) {
         $notes.Add("imagePath ignored because iqn is provided") | Out-Null
@@ -573,13 +595,18 @@ try {
     $VmVhdx = if ($VhdDir) { Join-Path $VhdDir "disk.vhdx" } else { $null }
     $SeedIso = Join-Path $SeedDir "seed-cidata.iso"
 
-    if (Get-VM -Name $Name -ErrorAction SilentlyContinue) { throw "a VM named '$Name' already exists" }
+    if (Get-VM -Name $Name -ErrorAction SilentlyContinue) { Throw-TaskError CONFLICT "a VM named '$Name' already exists" }
 
     if (-not $UseIscsi) {
+        Assert-NotCancelled
+        Write-TaskProgress -Percent 15 -Step "copy-vhdx" -Message "copying base image $BaseVhdx"
         Copy-Item -Path $BaseVhdx -Destination $VmVhdx -Force
         $createdVhdx = $VmVhdx
     }
 
+    Assert-NotCancelled
+    Write-TaskProgress -Percent 45 -Step "create-vm" -Message "creating VM '$Name'"
+
     $vmParams = @{
         Name               = $Name
         MemoryStartupBytes = $MemoryStartupBytes
@@ -631,6 +658,8 @@ try {
     # ----- iSCSI pass-through disk -----
     $iscsiDisk = $null
     if ($UseIscsi) {
+        Assert-NotCancelled
+        Write-TaskProgress -Percent 55 -Step "iscsi-connect" -Message "connecting iSCSI target $Iqn"
         $iscsiConnectedByUs = Ensure-IscsiTargetConnected -Iqn $Iqn -Portal $IscsiPortal
         Write-DebugLog -Message "iscsi connected portal=$IscsiPortal" -Ctx $ctx
         try { Update-HostStorageCache | Out-Null } catch {}
@@ -641,7 +670,7 @@ try {
         }
         $inUseBy = @(Get-VmNamesUsingDiskNumber -DiskNumber $iscsiDisk.Number)
         if ($inUseBy.Count -gt 0) {
-            throw "iSCSI disk $($iscsiDisk.Number) already attached to VM(s): $($inUseBy -join ', ')"
+            Throw-TaskError CONFLICT "iSCSI disk $($iscsiDisk.Number) already attached to VM(s): $($inUseBy -join ', ')"
         }
         if ($iscsiDisk.IsBoot -or $iscsiDisk.IsSystem) { throw "iSCSI disk cannot be a boot/system disk on the host (disk $($iscsiDisk.Number))" }
 
@@ -662,6 +691,8 @@ try {
     }
 
     # ----- cloud-init files + seed -----
+    Assert-NotCancelled
+    Write-TaskProgress -Percent 75 -Step "seed-iso" -Message "building cloud-init seed ISO"
     $tmp = Join-Path $env:TEMP ("cidata-" + [guid]::NewGuid().ToString())
     Ensure-Dir $tmp
     New-CloudInitFiles -dir $tmp -Name $Name -CI $CI
@@ -683,6 +714,8 @@ try {
         if ($sys) { Set-VMFirmware -VMName $Name -FirstBootDevice $sys | Out-Null }
     }
 
+    Assert-NotCancelled
+    Write-TaskProgress -Percent 90 -Step "start-vm" -Message "starting VM '$Name'"
     Start-VM -Name $Name | Out-Null
     Write-DebugLog -Message "vm started name=$Name" -Ctx $ctx
 
@@ -742,6 +775,7 @@ try {
 }
 catch {
     $errMsg = $_.Exception.Message
+    $errCode = $_.Exception.Data['code']
     $errFull = ($_ | Out-String).Trim()
     Write-DebugLog -Message ("error: {0}" -f $errMsg) -Ctx $ctx
 
@@ -797,6 +831,7 @@ catch {
         ok     = $false
         result = $null
         error  = $errMsg
+        code   = $errCode
         detail = $errFull
     } | ConvertTo-Json -Depth 6
     exit 1
@@ -3,6 +3,11 @@ param(
     [string]$InputJson
 )
 
+function Throw-TaskError {
+    param([Parameter(Mandatory = $true)][string]$Code, [Parameter(Mandatory = $true)][string]$Message)
+    $ex = [System.Exception]::new($Message); $ex.Data['code'] = $Code; throw $ex
+}
+
 $ErrorActionPreference = 'Stop'
 $ProgressPreference = 'SilentlyContinue'
 $WarningPreference = 'SilentlyContinue'
@@ -18,6 +23,13 @@ function To-Bool($v, $default = $false) {
         default { return [bool]$default }
     }
 }
+# --- Progress (canal STDERR lu par l'agent -> results / task.<id>.progress)
+function Write-TaskProgress {
+    param([int]$Percent, [string]$Step, [string]$Message)
+    $rec = [ordered]@{ percent = $Percent; step = $Step; message = $Message } | ConvertTo-Json -Compress
+    try { [Console]::Error.WriteLine("##openhvx:progress $rec") } catch {}
+}
+
 function Get-FullPath([string]$p) { if ([string]::IsNullOrWhiteSpace($p)) { return $null } [System.IO.Path]::GetFullPath($p) }
 function Assert-UnderRoot([string]$Candidate, [string]$Root) {
     if (-not $Root) { return }
@@ -63,6 +75,21 @@ function Invoke-WithRetry {
     }
     return $false
 }
+# Raison de ne pas toucher un fichier disque ($null = le supprimer / le mettre à la corbeille)
+function Get-DiskSkipReason {
+    param([string]$Path, [string]$Root, [string]$VmName)
+    if ($Root) {
+        try { Assert-UnderRoot -Candidate $Path -Root $Root } catch { return "outside managed root" }
+    }
+    try {
+        $refs = Get-VMHardDiskDrive -VMName * -ErrorAction SilentlyContinue |
+        Where-Object { $_.Path -eq $Path -and $_.VMName -ne $VmName }
+        if ($refs) { return "in use by other VM" }
+    }
+    catch {}
+    if (-not (Test-Path -LiteralPath $Path)) { return "file not found" }
+    return $null
+}
 function Get-VmNamesUsingDiskNumber {
     param([Parameter(Mandatory = $true)][int]$DiskNumber)
     $names = @()
@@ -116,7 +143,7 @@ try {
     $Id = $data.id; if (-not $Id -and $data.guid) { $Id = $data.guid }
     if (-not $Id -and $data.refId) { $Id = $data.refId }
     $Name = $data.name
-    if (-not $Id -and [string]::IsNullOrWhiteSpace($Name)) { throw "provide 'id/guid/refId' (VM GUID) or 'name'" }
+    if (-not $Id -and [string]::IsNullOrWhiteSpace($Name)) { Throw-TaskError INVALID_INPUT "provide 'id/guid/refId' (VM GUID) or 'name'" }
 
     $ForceStop = To-Bool $data.forceStop   $false
     $DeleteDisks = To-Bool $data.deleteDisks $false
@@ -135,6 +162,8 @@ try {
             elseif ($vhdRoot) { $trashRoot = Join-Path $vhdRoot "_trash" }
         }
     }
+    # Dry-run (__ctx.dryRun): décrire l'arrêt, les disques et la corbeille sans rien modifier
+    $DryRun = [bool]($ctx -and $ctx.dryRun)
 
     # === Résolution VM ===
     $vm = $null
@@ -148,7 +177,9 @@ try {
             id       = $Id
             guid     = $Id
         }
-        @{ vm = $result } | ConvertTo-Json -Depth 6
+        $out = @{ vm = $result }
+        if ($DryRun) { $out.dryRun = $true }
+        $out | ConvertTo-Json -Depth 6
         exit 0
     }
 
@@ -180,14 +211,57 @@ try {
         }
     }
 
+    # === Corbeille: <trash>/<tenant>/<stamp>-<vm>-<guid> ===
+    $trashBase = $null
+    if (-not $DeleteDisks -and $diskPaths.Count -gt 0 -and $trashRoot) {
+        $stamp = (Get-Date -Format "yyyyMMdd-HHmmss")
+        $sub = if ($tenantId) { Join-Path $tenantId "$stamp-$vmName-$vmGuid" } else { "$stamp-$vmName-$vmGuid" }
+        $trashBase = Join-Path $trashRoot $sub
+        if ($rootPath) { Assert-UnderRoot -Candidate $trashBase -Root $rootPath }
+    }
+
+    # === Dry-run: plan puis sortie, sans arrêt ni opération disque ===
+    if ($DryRun) {
+        $planDisks = @()
+        foreach ($p in $diskPaths) {
+            if ([string]::IsNullOrWhiteSpace($p)) { continue }
+            $reason = Get-DiskSkipReason -Path $p -Root $rootPath -VmName $vmName
+            if ($reason) { $planDisks += @{ path = $p; action = "skip"; reason = $reason } }
+            elseif ($DeleteDisks) { $planDisks += @{ path = $p; action = "delete" } }
+            elseif ($trashBase) { $planDisks += @{ path = $p; action = "trash"; to = (Join-Path $trashBase ([System.IO.Path]::GetFileName($p))) } }
+            else { $planDisks += @{ path = $p; action = "skip"; reason = "no trash path; leaving in place" } }
+        }
+        $planPassThrough = @()
+        foreach ($n in $passThroughNumbers) {
+            if ($null -eq $n) { continue }
+            $targets = @(Get-IscsiSessionsByDiskNumber -DiskNumber $n | Where-Object { $_ } | ForEach-Object { $_.targetName } | Select-Object -Unique)
+            $planPassThrough += @{ diskNumber = $n; iscsiTargets = $targets }
+        }
+        $plan = [ordered]@{
+            dryRun           = $true
+            vm               = @{ name = $vmName; id = $vmGuid; guid = $vmGuid; state = "$($vm.State)" }
+            willStop         = $wasRunning
+            forceStop        = $ForceStop # sans forceStop, un arrêt refusé donne CONFLICT
+            detachMedia      = @($isoPaths).Count
+            detachDisks      = @($hddObjs).Count
+            passThroughDisks = $planPassThrough
+            disks            = $planDisks
+            trashPath        = $trashBase
+            removeVm         = $true
+        }
+        $plan | ConvertTo-Json -Depth 8
+        exit 0
+    }
+
     # === Stop VM si nécessaire ===
     $stopped = $false
     if ($wasRunning) {
+        Write-TaskProgress -Percent 10 -Step "stop-vm" -Message "stopping VM '$vmName'"
         if ($ForceStop) {
             try { Stop-VM -Name $vmName -TurnOff -Force -ErrorAction Stop } catch { Stop-VM -Name $vmName -Force -ErrorAction Stop }
         }
         else {
-            try { Stop-VM -Name $vmName -Force -ErrorAction Stop } catch { throw "VM is running. Use 'forceStop: true' to force shutdown" }
+            try { Stop-VM -Name $vmName -Force -ErrorAction Stop } catch { Throw-TaskError CONFLICT "VM is running. Use 'forceStop: true' to force shutdown" }
         }
         $deadline = (Get-Date).AddSeconds($WaitForStop)
         do {
@@ -198,6 +272,7 @@ try {
     }
 
     # === Éjecter ISO + détacher VHD avant opérations FS ===
+    Write-TaskProgress -Percent 30 -Step "detach-disks" -Message "detaching disks and media"
     foreach ($d in $dvdObjs) {
         try {
             Set-VMDvdDrive -VMName $vmName -ControllerType $d.ControllerType -ControllerNumber $d.ControllerNumber -ControllerLocation $d.ControllerLocation -Path $null -ErrorAction SilentlyContinue
@@ -239,34 +314,18 @@ try {
     $movedDisks = @()
     $skippedDisks = @()
 
-    $trashBase = $null
-    if (-not $DeleteDisks -and $diskPaths.Count -gt 0 -and $trashRoot) {
-        $stamp = (Get-Date -Format "yyyyMMdd-HHmmss")
-        $sub = if ($tenantId) { Join-Path $tenantId "$stamp-$vmName-$vmGuid" } else { "$stamp-$vmName-$vmGuid" }
-        $trashBase = Join-Path $trashRoot $sub
-        if ($rootPath) { Assert-UnderRoot -Candidate $trashBase -Root $rootPath }
-        Ensure-Dir $trashBase
-    }
+    if ($trashBase) { Ensure-Dir $trashBase }
 
     # === Déplacer/Supprimer fichiers ===
+    if ($diskPaths.Count -gt 0) {
+        $verb = if ($DeleteDisks) { "deleting" } else { "moving to trash" }
+        Write-TaskProgress -Percent 50 -Step "disks" -Message "$verb $($diskPaths.Count) disk file(s)"
+    }
     foreach ($p in $diskPaths) {
         if ([string]::IsNullOrWhiteSpace($p)) { continue }
 
-        if ($rootPath) {
-            try { Assert-UnderRoot -Candidate $p -Root $rootPath }
-            catch { $skippedDisks += @{ path = $p; reason = "outside managed root" }; continue }
-        }
-
-        $stillInUse = $false
-        try {
-            $refs = Get-VMHardDiskDrive -VMName * -ErrorAction SilentlyContinue |
-            Where-Object { $_.Path -eq $p -and $_.VMName -ne $vmName }
-            if ($refs) { $stillInUse = $true }
-        }
-        catch {}
-        if ($stillInUse) { $skippedDisks += @{ path = $p; reason = "in use by other VM" }; continue }
-
-        if (-not (Test-Path -LiteralPath $p)) { $skippedDisks += @{ path = $p; reason = "file not found" }; continue }
+        $reason = Get-DiskSkipReason -Path $p -Root $rootPath -VmName $vmName
+        if ($reason) { $skippedDisks += @{ path = $p; reason = $reason }; continue }
 
         [void](Wait-FileUnlocked -Path $p -TimeoutMs 15000 -ProbeMs 250)
 
@@ -301,6 +360,7 @@ try {
     }
 
     # === Supprimer la VM (config) en dernier ===
+    Write-TaskProgress -Percent 90 -Step "remove-vm" -Message "removing VM '$vmName'"
     try { Remove-VM -Name $vmName -Force -ErrorAction Stop } catch {
         $vmGone = $false
         try { $vmGone = -not (Get-VM -Name $vmName -ErrorAction SilentlyContinue) } catch {}
@@ -325,6 +385,6 @@ try {
 catch {
     $msg = $_.Exception.Message
     $detail = $_ | Out-String
-    @{ ok = $false; error = $msg; detail = $detail } | ConvertTo-Json -Depth 8
+    @{ ok = $false; error = $msg; code = $_.Exception.Data['code']; detail = $detail } | ConvertTo-Json -Depth 8
     exit 1
 }
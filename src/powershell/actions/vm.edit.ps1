@@ -5,14 +5,21 @@
 # Input  : JSON via -InputJson or STDIN. Supports an envelope { action, data:{...} }.
 #          Required: { name: "currentName", ... }
 #          Optional rename: { new_name: "newName" }  (works online; no stop required)
+#          __ctx.dryRun = true -> plan only, nothing is changed
 # Output :
 #   Success -> { vm:{...}, notes:[...] }
-#   Error   -> writes to STDERR and exits 1
+#   Dry-run -> { dryRun:true, vm:{...}, changes:[{ setting, from, to }], needsStop, willStop }
+#   Error   -> { ok:false, error, code } on STDOUT (+ STDERR) and exits 1
 
 param(
     [string]$InputJson
 )
 
+function Throw-TaskError {
+    param([Parameter(Mandatory = $true)][string]$Code, [Parameter(Mandatory = $true)][string]$Message)
+    $ex = [System.Exception]::new($Message); $ex.Data['code'] = $Code; throw $ex
+}
+
 # --- IMPORTANT: no useless STDOUT ---
 $ErrorActionPreference = 'Stop'
 $ProgressPreference = 'SilentlyContinue'
@@ -26,8 +33,8 @@ function Read-TaskInput {
     param([string]$Inline)
     if ($Inline) { try { return ($Inline | ConvertFrom-Json -ErrorAction Stop) } catch {} }
     $raw = [Console]::In.ReadToEnd()
-    if ([string]::IsNullOrWhiteSpace($raw)) { throw "No JSON input (use -InputJson or pipe JSON to STDIN)" }
-    try { return ($raw | ConvertFrom-Json -ErrorAction Stop) } catch { throw "Invalid JSON input" }
+    if ([string]::IsNullOrWhiteSpace($raw)) { Throw-TaskError INVALID_INPUT "No JSON input (use -InputJson or pipe JSON to STDIN)" }
+    try { return ($raw | ConvertFrom-Json -ErrorAction Stop) } catch { Throw-TaskError INVALID_INPUT "Invalid JSON input" }
 }
 
 # ---------- Helpers (sizes) ----------
@@ -52,7 +59,7 @@ function Resolve-SizeBytes {
             'TB' { return [int64]($num * 1TB) }
         }
     }
-    throw "invalid size: '$InputValue'"
+    Throw-TaskError INVALID_INPUT "invalid size: '$InputValue'"
 }
 
 # ---------- Helpers (power/stop decision) ----------
@@ -108,11 +115,15 @@ try {
     $task = Read-TaskInput -Inline $InputJson
     $d = if ($task.PSObject.Properties.Name -contains 'data' -and $task.data) { $task.data } else { $task }
 
-    if ([string]::IsNullOrWhiteSpace($d.name)) { throw "missing 'name'" }
+    if ([string]::IsNullOrWhiteSpace($d.name)) { Throw-TaskError INVALID_INPUT "missing 'name'" }
     $Name = $d.name.Trim()
 
     $vm = Get-VM -Name $Name -ErrorAction SilentlyContinue
-    if (-not $vm) { throw "VM '$Name' not found" }
+    if (-not $vm) { Throw-TaskError NOT_FOUND "VM '$Name' not found" }
+
+    # Dry-run (__ctx.dryRun): mêmes contrôles et calculs, aucune modification
+    $DryRun = [bool]($d.__ctx -and $d.__ctx.dryRun)
+    $changes = New-Object System.Collections.Generic.List[object]
 
     # ----- Optional rename (works online) -----
     # UI/API convention: send { name:"oldName", new_name:"newName" } if renaming.
@@ -120,13 +131,18 @@ try {
         $NewName = $d.new_name.Trim()
         if ($NewName -ne $Name) {
             if (Get-VM -Name $NewName -ErrorAction SilentlyContinue) {
-                throw "a VM named '$NewName' already exists"
+                Throw-TaskError CONFLICT "a VM named '$NewName' already exists"
+            }
+            if ($DryRun) {
+                $changes.Add([ordered]@{ setting = 'name'; from = $Name; to = $NewName }) | Out-Null
+            }
+            else {
+                Rename-VM -VM $vm -NewName $NewName -ErrorAction Stop
+                $notes.Add("VM renamed: '$Name' -> '$NewName'") | Out-Null
+                # refresh handles and continue with the new name
+                $Name = $NewName
+                $vm = Get-VM -Name $Name -ErrorAction Stop
             }
-            Rename-VM -VM $vm -NewName $NewName -ErrorAction Stop
-            $notes.Add("VM renamed: '$Name' -> '$NewName'") | Out-Null
-            # refresh handles and continue with the new name
-            $Name = $NewName
-            $vm = Get-VM -Name $Name -ErrorAction Stop
         }
     }
 
@@ -143,26 +159,11 @@ try {
         $desired['com1_path'] = "$($d.serial.com1.path)".Trim()
     }
 
-    # Determine if a stop is required, remember previous state
-    $needStop = Get-NeedsStop -VM $vm -Desired $desired
-    $wasRunning = $vm.State -eq 'Running'
-    if ($needStop -and $wasRunning) {
-        Stop-VM -VM $vm -Force -TurnOff:$false -ErrorAction Stop | Out-Null
-        $notes.Add("VM stopped to apply changes requiring power-off") | Out-Null
-        $vm = Get-VM -Name $Name
-    }
-
-    # ----- CPU -----
-    if ($desired.ContainsKey('cpu') -and $null -ne $desired.cpu) {
-        if ($desired.cpu -lt 1) { throw "invalid 'cpu' (<1)" }
-        if ($desired.cpu -ne $vm.ProcessorCount) {
-            Set-VM -VM $vm -ProcessorCount $desired.cpu -ErrorAction Stop | Out-Null
-            $notes.Add("CPU set to $($desired.cpu)") | Out-Null
-        }
-    }
+    if ($desired.ContainsKey('cpu') -and $null -ne $desired.cpu -and $desired.cpu -lt 1) { Throw-TaskError INVALID_INPUT "invalid 'cpu' (<1)" }
 
-    # ----- Memory (static / dynamic) -----
+    # ----- Memory targets (static / dynamic) -----
     $memChanged = $false
+    $memChange = $false
     $startupBytes = $null; $minBytes = $null; $maxBytes = $null
     $wantDyn = $vm.DynamicMemoryEnabled
 
@@ -201,16 +202,81 @@ try {
                 -or $vm.MemoryStartup -ne $startupBytes `
                 -or $vm.MinimumMemory -ne $minBytes `
                 -or $vm.MaximumMemory -ne $maxBytes) {
-            Set-VMMemory -VM $vm -DynamicMemoryEnabled $true -MinimumBytes $minBytes -MaximumBytes $maxBytes -StartupBytes $startupBytes -ErrorAction Stop | Out-Null
-            $memChanged = $true
+            $memChange = $true
         }
     }
     else {
         # Static memory
         if ($vm.DynamicMemoryEnabled -ne $false -or $vm.MemoryStartup -ne $startupBytes) {
+            $memChange = $true
+        }
+    }
+
+    # Determine if a stop is required, remember previous state
+    $needStop = Get-NeedsStop -VM $vm -Desired $desired
+    $wasRunning = $vm.State -eq 'Running'
+
+    # ----- Dry-run: décrire les changements puis sortir -----
+    if ($DryRun) {
+        if ($desired.ContainsKey('cpu') -and $null -ne $desired.cpu -and $desired.cpu -ne $vm.ProcessorCount) {
+            $changes.Add([ordered]@{ setting = 'cpu'; from = $vm.ProcessorCount; to = $desired.cpu }) | Out-Null
+        }
+        if ($memChange) {
+            $changes.Add([ordered]@{
+                    setting = 'memory'
+                    from    = @{ dynamic = [bool]$vm.DynamicMemoryEnabled; startup = [int64]$vm.MemoryStartup; min = [int64]$vm.MinimumMemory; max = [int64]$vm.MaximumMemory }
+                    to      = @{ dynamic = [bool]$wantDyn; startup = [int64]$startupBytes; min = if ($wantDyn) { [int64]$minBytes } else { $null }; max = if ($wantDyn) { [int64]$maxBytes } else { $null } }
+                }) | Out-Null
+        }
+        if ($vm.Generation -eq 2 -and $desired.ContainsKey('secure_boot')) {
+            $currentSb = "$((Get-VMFirmware -VM $vm).SecureBoot)"
+            $targetSb = if ($desired.secure_boot) { 'On' } else { 'Off' }
+            if ($currentSb -ne $targetSb) { $changes.Add([ordered]@{ setting = 'secure_boot'; from = $currentSb; to = $targetSb }) | Out-Null }
+        }
+        if ($desired.ContainsKey('switch') -and $desired.switch) {
+            $nic = Get-VMNetworkAdapter -VMName $Name -ErrorAction SilentlyContinue | Select-Object -First 1
+            if (-not $nic) { $changes.Add([ordered]@{ setting = 'switch'; from = $null; to = $desired.switch; note = "adapter 'net0' will be created" }) | Out-Null }
+            elseif ($nic.SwitchName -ne $desired.switch) { $changes.Add([ordered]@{ setting = 'switch'; from = $nic.SwitchName; to = $desired.switch }) | Out-Null }
+        }
+        if ($desired.ContainsKey('com1_path') -and $desired.com1_path) {
+            $com1 = Get-VMComPort -VMName $Name -Number 1 -ErrorAction SilentlyContinue
+            $currentPath = if ($com1) { $com1.Path } else { $null }
+            if ($currentPath -ne $desired.com1_path) { $changes.Add([ordered]@{ setting = 'com1'; from = $currentPath; to = $desired.com1_path }) | Out-Null }
+        }
+
+        [ordered]@{
+            dryRun    = $true
+            vm        = @{ name = $vm.Name; id = "$($vm.Id)"; guid = "$($vm.Id)"; state = "$($vm.State)" }
+            changes   = $changes
+            needsStop = [bool]$needStop
+            willStop  = [bool]($needStop -and $wasRunning) # arrêt puis redémarrage
+        } | ConvertTo-Json -Depth 10
+        exit 0
+    }
+
+    if ($needStop -and $wasRunning) {
+        Stop-VM -VM $vm -Force -TurnOff:$false -ErrorAction Stop | Out-Null
+        $notes.Add("VM stopped to apply changes requiring power-off") | Out-Null
+        $vm = Get-VM -Name $Name
+    }
+
+    # ----- CPU -----
+    if ($desired.ContainsKey('cpu') -and $null -ne $desired.cpu) {
+        if ($desired.cpu -ne $vm.ProcessorCount) {
+            Set-VM -VM $vm -ProcessorCount $desired.cpu -ErrorAction Stop | Out-Null
+            $notes.Add("CPU set to $($desired.cpu)") | Out-Null
+        }
+    }
+
+    # ----- Memory (static / dynamic), cibles calculées avant l'arrêt éventuel -----
+    if ($memChange) {
+        if ($wantDyn) {
+            Set-VMMemory -VM $vm -DynamicMemoryEnabled $true -MinimumBytes $minBytes -MaximumBytes $maxBytes -StartupBytes $startupBytes -ErrorAction Stop | Out-Null
+        }
+        else {
             Set-VMMemory -VM $vm -DynamicMemoryEnabled $false -StartupBytes $startupBytes -ErrorAction Stop | Out-Null
-            $memChanged = $true
         }
+        $memChanged = $true
     }
 
     if ($memChanged) {
@@ -299,6 +365,7 @@ try {
 }
 catch {
     $errMsg = $_.Exception.Message
-    Write-Error $errMsg
+    [Console]::Error.WriteLine($errMsg)
+    [pscustomobject]@{ ok = $false; error = $errMsg; code = $_.Exception.Data['code'] } | ConvertTo-Json -Compress
     exit 1
 }
@@ -4,18 +4,46 @@
 $ErrorActionPreference = 'Stop'
 $ProgressPreference = 'SilentlyContinue'
 
-# --- DEBUG dump du STDIN ---
+function Throw-TaskError {
+  param([Parameter(Mandatory = $true)][string]$Code, [Parameter(Mandatory = $true)][string]$Message)
+  $ex = [System.Exception]::new($Message); $ex.Data['code'] = $Code; throw $ex
+}
+
+# Champs secrets masqués dans les dumps de debug (liste transmise par l'agent: OPENHVX_REDACT_KEYS)
+$SecretKeys = @((@($env:OPENHVX_REDACT_KEYS, 'password,passwd,secret,token,ticket,apikey,privatekey,sshauthorizedkeys,chap') | Where-Object { $_ } | Select-Object -First 1) -split ',' | Where-Object { $_ })
+function Test-SecretKey {
+  param([string]$Name)
+  $n = ($Name -replace '[_-]', '').ToLowerInvariant()
+  foreach ($k in $SecretKeys) { if ($n.Contains($k)) { return $true } }
+  return $false
+}
+function Protect-Secrets {
+  param($Obj)
+  if ($null -eq $Obj -or $Obj -is [string] -or $Obj -is [ValueType]) { return $Obj }
+  if ($Obj -is [System.Collections.IDictionary]) {
+    $h = [ordered]@{}
+    foreach ($k in $Obj.Keys) { $h[$k] = if (Test-SecretKey $k) { '***' } else { Protect-Secrets $Obj[$k] } }
+    return $h
+  }
+  if ($Obj -is [System.Collections.IEnumerable]) { return , @($Obj | ForEach-Object { Protect-Secrets $_ }) }
+  $h = [ordered]@{}
+  foreach ($p in $Obj.PSObject.Properties) { $h[$p.Name] = if (Test-SecretKey $p.Name) { '***' } else { Protect-Secrets $p.Value } }
+  return $h
+}
+
+# --- DEBUG dump du STDIN (secrets masqués) ---
 $DbgDir = 'C:\ProgramData\openhvx\debug\vm.power'
 try { New-Item -ItemType Directory -Force -Path $DbgDir | Out-Null } catch {}
 $raw = [Console]::In.ReadToEnd()
 $stamp = (Get-Date).ToString('yyyy-MM-dd_HH-mm-ss.fff')
-try { Set-Content -LiteralPath (Join-Path $DbgDir "stdin_$stamp.json") -Value $raw -Encoding UTF8 -Force } catch {}
+try { Set-Content -LiteralPath (Join-Path $DbgDir "stdin_$stamp.json") -Value (Protect-Secrets ($raw | ConvertFrom-Json -ErrorAction Stop) | ConvertTo-Json -Depth 20) -Encoding UTF8 -Force } catch {}
 
 function Get-VmByIdOrName {
   param([Parameter(Mandatory = $true)][string]$IdOrName)
   $vm = $null
   try { $g = [guid]$IdOrName; $vm = Get-VM -Id $g -ErrorAction Stop } catch { }
-  if (-not $vm) { $vm = Get-VM -Name $IdOrName -ErrorAction Stop }
+  if (-not $vm) { $vm = Get-VM -Name $IdOrName -ErrorAction SilentlyContinue }
+  if (-not $vm) { Throw-TaskError NOT_FOUND "VM '$IdOrName' not found" }
   return $vm
 }
 function VmInfo {
@@ -36,22 +64,22 @@ function Normalize-State {
     'pause' { 'pause' } 'suspend' { 'pause' }
     'resume' { 'resume' }
     'save' { 'save' }
-    default { throw "unsupported state '$s' (use: on|off|shutdown|restart|pause|resume|save)" }
+    default { Throw-TaskError INVALID_INPUT "unsupported state '$s' (use: on|off|shutdown|restart|pause|resume|save)" }
   }
 }
 
 try {
-  if ([string]::IsNullOrWhiteSpace($raw)) { throw "no input on STDIN" }
+  if ([string]::IsNullOrWhiteSpace($raw)) { Throw-TaskError INVALID_INPUT "no input on STDIN" }
   $p = $raw | ConvertFrom-Json -ErrorAction Stop
-  if ($p.action -ne 'vm.power' -or -not $p.data) { throw "invalid payload (need action=vm.power + data)" }
+  if ($p.action -ne 'vm.power' -or -not $p.data) { Throw-TaskError INVALID_INPUT "invalid payload (need action=vm.power + data)" }
   $d = $p.data
 
   $idOrName = $d.guid; if (-not $idOrName) { $idOrName = $d.id }
   if (-not $idOrName) { $idOrName = $d.target.refId }
   if (-not $idOrName) { $idOrName = $d.target }   # legacy string
-  if (-not $idOrName) { throw "missing VM reference (data.guid|data.id|data.target.refId)" }
+  if (-not $idOrName) { Throw-TaskError INVALID_INPUT "missing VM reference (data.guid|data.id|data.target.refId)" }
 
-  if (-not $d.state) { throw "missing data.state" }
+  if (-not $d.state) { Throw-TaskError INVALID_INPUT "missing data.state" }
   $state = Normalize-State $d.state
 
   $vm = Get-VmByIdOrName -IdOrName $idOrName
@@ -75,13 +103,14 @@ try {
     when = (Get-Date).ToUniversalTime().ToString('o')
   }
   # log out pour debug
-  try { $result | ConvertTo-Json -Depth 20 | Set-Content -LiteralPath (Join-Path $DbgDir "out_$stamp.json") -Encoding UTF8 -Force } catch {}
+  try { Protect-Secrets $result | ConvertTo-Json -Depth 20 | Set-Content -LiteralPath (Join-Path $DbgDir "out_$stamp.json") -Encoding UTF8 -Force } catch {}
   $result | ConvertTo-Json -Depth 20 -Compress
   exit 0
 }
 catch {
   $msg = $_.Exception.Message
+  $code = $_.Exception.Data['code']
   try { Add-Content -LiteralPath (Join-Path $DbgDir "err_$stamp.log") -Value $msg } catch {}
-  [pscustomobject]@{ ok = $false; error = $msg } | ConvertTo-Json -Compress
+  [pscustomobject]@{ ok = $false; error = $msg; code = $code } | ConvertTo-Json -Compress
   exit 1
 }
\ No newline at end of file
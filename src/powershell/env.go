@@ -0,0 +1,122 @@
+package powershell
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Environnement des scripts et exécutables externes: pas d'héritage complet de celui de
+// l'agent (secrets, variables du service), seulement une liste blanche, les variables
+// OPENHVX_* de l'agent puis celles de la tâche (RunOpts.Env).
+
+// EnvPrefix: variables de l'agent toujours transmises (OPENHVX_REDACT_KEYS, ...).
+const EnvPrefix = "OPENHVX_"
+
+// defaultEnvAllow: le minimum pour pwsh, les modules (Hyper-V, iSCSI) et les binaires appelés.
+var defaultEnvAllow = []string{
+	"PATH", "PATHEXT", "SystemRoot", "SystemDrive", "windir", "ComSpec", "TEMP", "TMP",
+	"ProgramData", "ProgramFiles", "ProgramFiles(x86)", "ProgramW6432",
+	"CommonProgramFiles", "CommonProgramFiles(x86)", "CommonProgramW6432",
+	"ALLUSERSPROFILE", "PUBLIC", "APPDATA", "LOCALAPPDATA", "USERPROFILE", "USERNAME", "USERDOMAIN",
+	"COMPUTERNAME", "NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE", "OS", "PSModulePath",
+	"HOME", "LANG", "LC_ALL", "TZ", // Linux / macOS (dev)
+}
+
+var (
+	envMu    sync.RWMutex
+	envAllow = allowSet(nil)
+	workDir  string
+)
+
+// SetScriptEnv ajoute des variables à la liste blanche et fixe le dossier de travail des
+// scripts (créé si besoin; vide = dossier temporaire du système).
+func SetScriptEnv(extraAllow []string, dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	envMu.Lock()
+	envAllow = allowSet(extraAllow)
+	workDir = dir
+	envMu.Unlock()
+	return nil
+}
+
+// WorkDir renvoie le dossier de travail des scripts.
+func WorkDir() string {
+	envMu.RLock()
+	defer envMu.RUnlock()
+	if workDir == "" {
+		return os.TempDir()
+	}
+	return workDir
+}
+
+// allowSet: noms en majuscules (Windows ignore la casse des variables).
+func allowSet(extra []string) map[string]bool {
+	m := make(map[string]bool, len(defaultEnvAllow)+len(extra))
+	for _, k := range append(append([]string{}, defaultEnvAllow...), extra...) {
+		if k = strings.TrimSpace(k); k != "" {
+			m[strings.ToUpper(k)] = true
+		}
+	}
+	return m
+}
+
+// scriptEnv construit l'environnement effectif d'une exécution: variables autorisées de
+// l'agent, puis extra ("CLE=valeur", prioritaires).
+func scriptEnv(extra []string) []string {
+	envMu.RLock()
+	allow := envAllow
+	envMu.RUnlock()
+
+	var env []string
+	idx := map[string]int{}
+	add := func(kv string) {
+		k, _, ok := strings.Cut(kv, "=")
+		if !ok || k == "" { // Windows: entrées "=C:=C:\..." ignorées
+			return
+		}
+		key := strings.ToUpper(k)
+		if i, seen := idx[key]; seen {
+			env[i] = kv
+			return
+		}
+		idx[key] = len(env)
+		env = append(env, kv)
+	}
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if up := strings.ToUpper(k); allow[up] || strings.HasPrefix(up, EnvPrefix) {
+			add(kv)
+		}
+	}
+	for _, kv := range extra {
+		add(kv)
+	}
+	return env
+}
+
+// envMap: environnement sous forme de map (diagnostics).
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
+}
+
+// envNames: noms triés des variables (diagnostics: les valeurs peuvent contenir des secrets).
+func envNames(env []string) []string {
+	names := make([]string, 0, len(env))
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,61 +2,167 @@ package powershell
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
+	"time"
 )
 
-// RunActionScript exécute powershell/actions/<action>.ps1.
-//
-// - Passe les "data" (map) en JSON via l'argument nommé: -InputJson '<json>'.
-// - En parallèle, envoie sur STDIN un wrapper { "action": "<action>", "data": {...} } pour compatibilité.
-// - Si le script ne connaît pas -InputJson, on retente automatiquement sans ce paramètre.
+// ErrScriptNotFound est renvoyée quand aucun script ne correspond à l'action.
+var ErrScriptNotFound = errors.New("script not found")
+
+// ErrPwshNotFound est renvoyée quand ni pwsh ni powershell ne sont disponibles.
+var ErrPwshNotFound = errors.New("neither 'pwsh' nor 'powershell' found in PATH")
+
+// ErrTimeout est renvoyée quand le contexte d'exécution expire avant la fin du script.
+var ErrTimeout = errors.New("action timed out")
+
+// ErrCancelled est renvoyée quand le contexte d'exécution est annulé (annulation distante).
+var ErrCancelled = errors.New("action cancelled")
+
+// ErrOutputTooLarge est renvoyée quand STDOUT dépasse scriptStdoutMaxKB: la sortie
+// tronquée n'est pas exploitable (JSON incomplet).
+var ErrOutputTooLarge = errors.New("action output too large")
+
+// CancelFileEnv: variable d'environnement donnant au script le chemin du fichier
+// témoin créé à l'annulation (signal de grâce avant le kill).
+const CancelFileEnv = "OPENHVX_CANCEL_FILE"
+
+// Délai laissé à Wait() après le kill de l'arbre de processus (pipes tenus par des petits-enfants).
+const killWaitDelay = 5 * time.Second
+
+// RunActionScript exécute powershell/actions/<action>.ps1 sans échéance.
+// Voir RunActionScriptContext.
 func RunActionScript(action string, data map[string]any) ([]byte, error) {
-	ps, err := findPwsh()
+	return RunActionScriptContext(context.Background(), action, data)
+}
+
+// RunOpts regroupe les options d'exécution d'un script d'action.
+type RunOpts struct {
+	OnProgress func(Progress) // appelé pour chaque enregistrement de progression émis par le script
+	// Grace: sur annulation (pas sur échéance), délai laissé au script après création du
+	// fichier témoin $env:OPENHVX_CANCEL_FILE pour finir proprement (rollback) avant le kill.
+	Grace time.Duration
+	Diag  *Diagnostics // si non nil: rempli à la fin de l'exécution (code de sortie, tailles, fin de STDERR)
+	Env   []string     // variables "CLE=valeur" de la tâche (OPENHVX_TASK_ID, ...), voir SetScriptEnv
+	// Manifest: entrée du catalogue prise (et vérifiée) à l'admission de la tâche (nil =
+	// catalogue courant): la tâche finit sur cette version même après un rechargement.
+	Manifest *Manifest
+}
+
+// RunActionScriptContext exécute un script d'action sous ctx, sans options.
+// Voir RunActionScriptOpts.
+func RunActionScriptContext(ctx context.Context, action string, data map[string]any) ([]byte, error) {
+	return RunActionScriptOpts(ctx, action, data, RunOpts{})
+}
+
+// RunActionScriptOpts exécute powershell/actions/<action>.ps1 selon son manifest.
+//
+//   - Sans <action>.manifest.json, le script n'est pas exécuté (ErrNoManifest).
+//   - Le script exécuté est celui chargé avec le manifest (opts.Manifest, pris à l'admission, ou
+//     l'entrée courante du catalogue), via une copie versionnée (voir snapshotScript).
+//   - Si des clés d'intégrité sont configurées, un fichier modifié bloque l'exécution (ErrIntegrity):
+//     script, manifest et schéma à l'admission (Manifest.Verify), binaires de powershell/bin ici.
+//   - inputMode "InputJson": les "data" (map) passent en JSON via -InputJson '<json>'.
+//   - inputMode "stdin": un wrapper { "action": "<action>", "data": {...} } est envoyé sur STDIN.
+//   - En InputJson (hors oneShot), le script tourne dans un worker persistant si un worker est libre (voir StartHostPool).
+//   - Si ctx expire, tout l'arbre pwsh est tué (erreur: ErrTimeout).
+//   - Si ctx est annulé, le script est prévenu (opts.Grace) puis tué (erreur: ErrCancelled).
+//   - Les lignes STDERR préfixées par ProgressPrefix sont décodées et remontées à opts.OnProgress.
+func RunActionScriptOpts(ctx context.Context, action string, data map[string]any, opts RunOpts) ([]byte, error) {
+	m := opts.Manifest
+	if m == nil {
+		cur, ok := ActionManifest(action)
+		if !ok {
+			if _, err := resolveActionScript(action); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %s", ErrNoManifest, action)
+		}
+		if err := cur.Verify(); err != nil {
+			return nil, err
+		}
+		m = cur
+	}
+	// Intégrité: binaires de powershell/bin contre integrity.json signé
+	if err := checkIntegrity(action); err != nil {
+		return nil, err
+	}
+	script, err := m.snapshotScript()
 	if err != nil {
 		return nil, err
 	}
 
-	scriptPath, err := resolveActionScript(action)
+	ps, err := findPwsh()
 	if err != nil {
 		return nil, err
 	}
 
-	// JSON des "data" (pour -InputJson)
-	dataOnlyJSON, _ := json.Marshal(data)
+	args := []string{"-ExecutionPolicy", "Bypass", "-NoProfile", "-File", script}
+	var stdin []byte
+	if m.InputMode == InputModeStdin {
+		stdin, _ = json.Marshal(map[string]any{"action": action, "data": data})
+	} else {
+		dataJSON, _ := json.Marshal(data)
+		args = append(args, "-InputJson", string(dataJSON))
+
+		// Worker pwsh persistant si disponible (modules déjà chargés), sinon pwsh -File
+		if !m.OneShot {
+			if out, stderr, runErr, ok := runOnHost(ctx, script, dataJSON, opts); ok {
+				return finishRun(ctx, action, out, stderr, runErr)
+			}
+		}
+	}
 
-	// Payload STDIN compat: { action, data }
-	task := map[string]any{"action": action, "data": data}
-	stdinPayload, _ := json.Marshal(task)
+	out, stderr, runErr := runProcess(ctx, ps, args, stdin, opts)
+	if opts.Diag != nil {
+		opts.Diag.Runner = "pwsh"
+	}
+	return finishRun(ctx, action, out, stderr, runErr)
+}
 
-	// Tentative 1: avec -InputJson
-	args := []string{"-ExecutionPolicy", "Bypass", "-NoProfile", "-File", scriptPath, "-InputJson", string(dataOnlyJSON)}
-	out, stderr, runErr := runPwsh(ps, args, stdinPayload)
+// finishRun traduit le résultat d'une exécution (one-shot ou worker) pour l'appelant.
+func finishRun(ctx context.Context, action string, out, stderr []byte, runErr error) ([]byte, error) {
 	if runErr == nil {
 		return out, nil
 	}
+	if ctx.Err() != nil {
+		return out, contextError(ctx, action)
+	}
+	if errors.Is(runErr, errHostDied) {
+		return out, fmt.Errorf("action script failed: %w", runErr)
+	}
+	if errors.Is(runErr, ErrOutputTooLarge) {
+		return nil, runErr
+	}
+	return scriptFailure(out, stderr)
+}
 
-	// Si l'erreur mentionne un paramètre inconnu (-InputJson), on retente sans
-	if isUnknownParamError(stderr, "InputJson") {
-		args2 := []string{"-ExecutionPolicy", "Bypass", "-NoProfile", "-File", scriptPath}
-		out2, stderr2, runErr2 := runPwsh(ps, args2, stdinPayload)
-		if runErr2 == nil {
-			return out2, nil
-		}
-		// Echec 2: si le script a tout de même produit un JSON utile, on le renvoie avec une erreur générique
-		if len(out2) > 0 {
-			return out2, errors.New("action script failed")
-		}
-		return nil, errors.New("action script failed: " + strings.TrimSpace(string(stderr2)))
+// RunProcess exécute un exécutable quelconque avec la même mécanique que les scripts:
+// arbre de processus tué à l'échéance, grâce sur annulation, progression sur STDERR.
+func RunProcess(ctx context.Context, path string, args []string, stdin []byte, opts RunOpts) ([]byte, error) {
+	out, stderr, err := runProcess(ctx, path, args, stdin, opts)
+	if err == nil {
+		return out, nil
+	}
+	if ctx.Err() != nil {
+		return out, contextError(ctx, filepath.Base(path))
+	}
+	if errors.Is(err, ErrOutputTooLarge) {
+		return nil, err
 	}
+	return scriptFailure(out, stderr)
+}
 
-	// Echec 1 "classique"
+// scriptFailure: erreur d'un script terminé en échec, stdout conservé s'il existe.
+func scriptFailure(out, stderr []byte) ([]byte, error) {
 	if len(out) > 0 {
 		if len(stderr) > 0 {
 			return out, errors.New("action script failed: " + strings.TrimSpace(string(stderr)))
@@ -64,20 +170,66 @@ func RunActionScript(action string, data map[string]any) ([]byte, error) {
 		return out, errors.New("action script failed")
 	}
 	return nil, errors.New("action script failed: " + strings.TrimSpace(string(stderr)))
+}
 
+// contextError traduit la fin du contexte en erreur exploitable par l'appelant.
+func contextError(ctx context.Context, action string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s", ErrTimeout, action)
+	}
+	return fmt.Errorf("%w: %s (%v)", ErrCancelled, action, context.Cause(ctx))
 }
 
-func runPwsh(ps string, args []string, stdin []byte) ([]byte, []byte, error) {
-	cmd := exec.Command(ps, args...)
+func runProcess(ctx context.Context, ps string, args []string, stdin []byte, opts RunOpts) ([]byte, []byte, error) {
+	cmd := exec.CommandContext(ctx, ps, args...)
+	// Groupe de processus dédié: à l'échéance on tue pwsh ET ses enfants (iscsicli, bridge, ...)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessTree(cmd.Process) }
+	cmd.WaitDelay = killWaitDelay
+	// Environnement explicite (liste blanche + OPENHVX_*) et dossier de travail fixe
+	env := scriptEnv(opts.Env)
+	cmd.Dir = WorkDir()
+
+	// Annulation avec grâce: fichier témoin d'abord, kill de l'arbre ensuite
+	exited := make(chan struct{})
+	defer close(exited)
+	if opts.Grace > 0 {
+		cancelFile := filepath.Join(os.TempDir(), fmt.Sprintf("openhvx-cancel-%d-%d", os.Getpid(), time.Now().UnixNano()))
+		defer os.Remove(cancelFile)
+		env = append(env, CancelFileEnv+"="+cancelFile)
+		cmd.Cancel = func() error {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return killProcessTree(cmd.Process)
+			}
+			_ = os.WriteFile(cancelFile, []byte(context.Cause(ctx).Error()), 0o644)
+			proc := cmd.Process
+			go func() {
+				select {
+				case <-time.After(opts.Grace):
+					_ = killProcessTree(proc)
+				case <-exited:
+				}
+			}()
+			return nil
+		}
+		cmd.WaitDelay = opts.Grace + killWaitDelay
+	}
+	cmd.Env = env
+	opts.Diag.setEnv(env, cmd.Dir)
 	if len(stdin) > 0 {
 		cmd.Stdin = bytes.NewReader(stdin)
 	}
 
-	var out, stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
+	// Sorties plafonnées (SetOutputLimits): début de STDOUT, fin de STDERR
+	out, stderr := newHeadBuffer(), newTailBuffer()
+	pw := &progressWriter{onProgress: opts.OnProgress, rest: stderr}
+	cmd.Stdout = out
+	cmd.Stderr = pw
 
+	start := time.Now()
 	err := cmd.Run()
+	pw.flush()
+	opts.Diag.record("process", start, exitCodeOf(cmd.ProcessState), out, stderr)
 	if err != nil {
 		// On renvoie quand même stdout/stderr pour analyse
 		return out.Bytes(), stderr.Bytes(), err
@@ -85,25 +237,21 @@ func runPwsh(ps string, args []string, stdin []byte) ([]byte, []byte, error) {
 	if out.Len() == 0 {
 		return nil, nil, errors.New("empty action output")
 	}
+	if err := out.tooLarge(); err != nil {
+		return nil, stderr.Bytes(), err
+	}
 	return out.Bytes(), stderr.Bytes(), nil
 }
 
-func isUnknownParamError(stderr []byte, param string) bool {
-	// Exemples de messages:
-	// "A parameter cannot be found that matches parameter name 'InputJson'."
-	// "Un paramètre ne peut pas être trouvé qui correspond au nom du paramètre « InputJson »."
-	s := strings.ToLower(string(stderr))
-	return strings.Contains(s, "parameter cannot be found") &&
-		strings.Contains(s, strings.ToLower(param))
+func resolveActionScript(action string) (string, error) {
+	rel := filepath.Join("powershell", "actions", safeActionName(action)+".ps1")
+	return resolveScript(rel)
 }
 
-func resolveActionScript(action string) (string, error) {
-	// Sécurise le nom de fichier: lettres, chiffres, ., -, _
-	safe := strings.ToLower(action)
+// safeActionName sécurise le nom de fichier: lettres, chiffres, ., -, _
+func safeActionName(action string) string {
 	re := regexp.MustCompile(`[^a-z0-9._-]`)
-	safe = re.ReplaceAllString(safe, "-")
-	rel := filepath.Join("powershell", "actions", safe+".ps1")
-	return resolveScript(rel)
+	return re.ReplaceAllString(strings.ToLower(action), "-")
 }
 
 // --- Helpers réutilisables ---
@@ -123,7 +271,7 @@ func findPwsh() (string, error) {
 	if p, err := exec.LookPath("powershell"); err == nil {
 		return p, nil
 	}
-	return "", errors.New("neither 'pwsh' nor 'powershell' found in PATH")
+	return "", ErrPwshNotFound
 }
 
 func resolveScript(rel string) (string, error) {
@@ -142,5 +290,5 @@ func resolveScript(rel string) (string, error) {
 			return alt, nil
 		}
 	}
-	return "", errors.New("script not found: " + rel)
+	return "", fmt.Errorf("%w: %s", ErrScriptNotFound, rel)
 }
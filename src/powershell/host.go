@@ -0,0 +1,575 @@
+package powershell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Préfixes du protocole host.ps1 (STDOUT: réponses, STDERR: fin des enregistrements d'un job).
+const (
+	hostFramePrefix = "##openhvx:frame "
+	hostEndPrefix   = "##openhvx:end "
+)
+
+var errHostDied = errors.New("powershell host exited during job")
+
+// HostPoolOpts configure le pool de workers pwsh persistants (host.ps1).
+type HostPoolOpts struct {
+	Size         int           // nombre de workers
+	MaxJobs      int           // recyclage d'un worker après N jobs (0 = jamais)
+	MaxGrowthMB  int           // recyclage si le working set dépasse celui du démarrage de N Mo (0 = jamais)
+	StartTimeout time.Duration // démarrage + import des modules (défaut 60s)
+	PingTimeout  time.Duration // réponse à un ping (défaut 5s)
+	HealthEvery  time.Duration // ping périodique des workers inactifs (défaut 30s)
+}
+
+var (
+	hostMu   sync.Mutex
+	hostPool *pwshHostPool
+)
+
+// StartHostPool démarre (en arrière-plan) le pool de workers persistants. Tant qu'il
+// n'est pas démarré, ou si tous les workers sont occupés, les scripts tournent en
+// one-shot (pwsh -File).
+func StartHostPool(opts HostPoolOpts) error {
+	if opts.Size <= 0 {
+		return nil
+	}
+	if opts.StartTimeout <= 0 {
+		opts.StartTimeout = 60 * time.Second
+	}
+	if opts.PingTimeout <= 0 {
+		opts.PingTimeout = 5 * time.Second
+	}
+	if opts.HealthEvery <= 0 {
+		opts.HealthEvery = 30 * time.Second
+	}
+	ps, err := findPwsh()
+	if err != nil {
+		return err
+	}
+	script, err := resolveScript(filepath.Join("powershell", "host.ps1"))
+	if err != nil {
+		return err
+	}
+
+	p := &pwshHostPool{opts: opts, ps: ps, script: script, stop: make(chan struct{})}
+	hostMu.Lock()
+	if hostPool != nil {
+		hostMu.Unlock()
+		return errors.New("powershell host pool already started")
+	}
+	hostPool = p
+	hostMu.Unlock()
+
+	for i := 0; i < opts.Size; i++ {
+		p.mu.Lock()
+		p.total++
+		p.mu.Unlock()
+		go p.spawn()
+	}
+	go p.healthLoop()
+	log.Printf("[PS] host pool starting | workers=%d maxJobs=%d maxGrowthMB=%d", opts.Size, opts.MaxJobs, opts.MaxGrowthMB)
+	return nil
+}
+
+// StopHostPool arrête les workers inactifs; ceux occupés s'arrêtent à la fin de leur job.
+func StopHostPool() {
+	hostMu.Lock()
+	p := hostPool
+	hostPool = nil
+	hostMu.Unlock()
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	close(p.stop)
+	for _, w := range idle {
+		w.shutdown()
+	}
+}
+
+// runOnHost exécute le script dans un worker persistant. ok=false: pas de worker
+// utilisable (pool absent ou occupé), l'appelant bascule en one-shot.
+func runOnHost(ctx context.Context, scriptPath string, inputJSON []byte, opts RunOpts) (out, stderr []byte, err error, ok bool) {
+	hostMu.Lock()
+	p := hostPool
+	hostMu.Unlock()
+	if p == nil {
+		return nil, nil, nil, false
+	}
+	// Déjà annulée (task.cancel pendant l'attente du verrou / d'un slot): ne rien lancer
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err, true
+	}
+	// Sans -InputJson, un script lirait [Console]::In, c'est-à-dire le protocole du worker:
+	// pwsh -File (STDIN vide) à la place
+	if len(bytes.TrimSpace(inputJSON)) == 0 {
+		return nil, nil, nil, false
+	}
+	w := p.acquire()
+	if w == nil {
+		return nil, nil, nil, false
+	}
+	out, stderr, err = w.run(ctx, scriptPath, inputJSON, opts)
+	p.release(w)
+	return out, stderr, err, true
+}
+
+// ---------------- pool ----------------
+
+type pwshHostPool struct {
+	opts   HostPoolOpts
+	ps     string
+	script string
+	stop   chan struct{}
+
+	mu     sync.Mutex
+	idle   []*hostWorker
+	total  int // workers vivants ou en démarrage
+	closed bool
+}
+
+// acquire renvoie un worker inactif, ou nil si aucun n'est prêt (pas d'attente).
+func (p *pwshHostPool) acquire() *hostWorker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.idle) > 0 {
+		w := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if w.alive() {
+			return w
+		}
+		p.total--
+		go p.refill()
+	}
+	return nil
+}
+
+// release remet le worker dans le pool, ou le recycle (mort, trop de jobs, mémoire).
+func (p *pwshHostPool) release(w *hostWorker) {
+	reason := ""
+	switch {
+	case !w.alive():
+		reason = "exited"
+	case p.opts.MaxJobs > 0 && w.jobs >= p.opts.MaxJobs:
+		reason = fmt.Sprintf("%d jobs", w.jobs)
+	default:
+		if f, err := w.ping(p.opts.PingTimeout); err != nil {
+			reason = "ping: " + err.Error()
+		} else if grown := (f.WorkingSet - w.baseWS) >> 20; p.opts.MaxGrowthMB > 0 && grown > int64(p.opts.MaxGrowthMB) {
+			reason = fmt.Sprintf("working set +%dMB", grown)
+		}
+	}
+
+	p.mu.Lock()
+	if reason == "" && !p.closed {
+		p.idle = append(p.idle, w)
+		p.mu.Unlock()
+		return
+	}
+	p.total--
+	closed := p.closed
+	p.mu.Unlock()
+
+	if reason != "" {
+		log.Printf("[PS] host pid=%d recycled (%s)", w.pid(), reason)
+	}
+	w.shutdown()
+	if !closed {
+		p.refill()
+	}
+}
+
+// refill démarre un worker de remplacement si le pool est sous sa taille.
+func (p *pwshHostPool) refill() {
+	p.mu.Lock()
+	if p.closed || p.total >= p.opts.Size {
+		p.mu.Unlock()
+		return
+	}
+	p.total++
+	p.mu.Unlock()
+	go p.spawn()
+}
+
+// spawn démarre un worker (total déjà incrémenté par l'appelant).
+func (p *pwshHostPool) spawn() {
+	err := checkIntegrity("", p.script)
+	var w *hostWorker
+	if err == nil {
+		w, err = startHostWorker(p.ps, p.script, p.opts.StartTimeout)
+	}
+	if err != nil {
+		log.Printf("[PS] host start failed: %v", err)
+		p.mu.Lock()
+		p.total--
+		p.mu.Unlock()
+		// Nouvel essai au prochain passage du health check
+		return
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.total--
+		p.mu.Unlock()
+		w.shutdown()
+		return
+	}
+	p.idle = append(p.idle, w)
+	p.mu.Unlock()
+	log.Printf("[PS] host ready | pid=%d workingSet=%dMB", w.pid(), w.baseWS>>20)
+}
+
+// healthLoop ping les workers inactifs (release recycle ceux qui ne répondent pas) et complète le pool.
+func (p *pwshHostPool) healthLoop() {
+	t := time.NewTicker(p.opts.HealthEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+		}
+		p.mu.Lock()
+		batch := p.idle
+		p.idle = nil
+		p.mu.Unlock()
+		for _, w := range batch {
+			p.release(w)
+		}
+		for i := 0; i < p.opts.Size; i++ {
+			p.refill()
+		}
+	}
+}
+
+// ---------------- worker ----------------
+
+type hostFrame struct {
+	ID          int64  `json:"id"`
+	OK          bool   `json:"ok"`
+	Ready       bool   `json:"ready,omitempty"`
+	PID         int    `json:"pid,omitempty"`
+	WorkingSet  int64  `json:"workingSet,omitempty"`
+	ExitCode    int    `json:"exitCode,omitempty"`
+	Stdout      string `json:"stdout,omitempty"`
+	StdoutBytes int64  `json:"stdoutBytes,omitempty"` // taille réelle (stdout tronqué au-delà de maxStdout)
+	Error       string `json:"error,omitempty"`
+}
+
+type hostWorker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	frames chan hostFrame
+	ends   chan int64
+	done   chan struct{} // fermé à la sortie du process
+
+	sinkMu sync.Mutex
+	sink   io.Writer // STDERR du job en cours
+
+	seq    atomic.Int64
+	jobs   int
+	baseWS int64
+}
+
+func startHostWorker(ps, script string, timeout time.Duration) (*hostWorker, error) {
+	cmd := exec.Command(ps, "-ExecutionPolicy", "Bypass", "-NoProfile", "-NonInteractive", "-File", script)
+	setProcessGroup(cmd)
+	// Environnement de base du worker; les variables de chaque tâche sont passées avec le job
+	cmd.Env = scriptEnv(nil)
+	cmd.Dir = WorkDir()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	w := &hostWorker{
+		cmd:    cmd,
+		stdin:  stdin,
+		frames: make(chan hostFrame, 8),
+		ends:   make(chan int64, 8),
+		done:   make(chan struct{}),
+	}
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() { defer readers.Done(); w.readStdout(stdout) }()
+	go func() { defer readers.Done(); w.readStderr(stderr) }()
+	go func() {
+		readers.Wait()
+		_ = cmd.Wait()
+		close(w.done)
+	}()
+
+	// Trame "ready" (id 0) une fois les modules importés
+	select {
+	case f := <-w.frames:
+		if !f.Ready {
+			w.kill()
+			return nil, fmt.Errorf("unexpected first frame from host (id=%d)", f.ID)
+		}
+		w.baseWS = f.WorkingSet
+		return w, nil
+	case <-w.done:
+		return nil, errors.New("host exited during startup")
+	case <-time.After(timeout):
+		w.kill()
+		return nil, fmt.Errorf("host not ready after %s", timeout)
+	}
+}
+
+func (w *hostWorker) readStdout(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if i := bytes.Index(line, []byte(hostFramePrefix)); i >= 0 {
+			var f hostFrame
+			if jErr := json.Unmarshal(bytes.TrimSpace(line[i+len(hostFramePrefix):]), &f); jErr != nil {
+				log.Printf("[PS] host pid=%d invalid frame: %v", w.pid(), jErr)
+			} else {
+				select {
+				case w.frames <- f:
+				default:
+					log.Printf("[PS] host pid=%d frame dropped (id=%d)", w.pid(), f.ID)
+				}
+			}
+		} else if s := bytes.TrimSpace(line); len(s) > 0 {
+			log.Printf("[PS] host pid=%d stdout: %.200s", w.pid(), s)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (w *hostWorker) readStderr(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if bytes.HasPrefix(trimmed, []byte(hostEndPrefix)) {
+			var id int64
+			if _, sErr := fmt.Sscan(string(trimmed[len(hostEndPrefix):]), &id); sErr == nil {
+				select {
+				case w.ends <- id:
+				default:
+				}
+			}
+		} else if len(line) > 0 {
+			w.sinkMu.Lock()
+			if w.sink != nil {
+				_, _ = w.sink.Write(line)
+			} else if len(bytes.TrimSpace(line)) > 0 {
+				log.Printf("[PS] host pid=%d stderr: %.200s", w.pid(), trimmed)
+			}
+			w.sinkMu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (w *hostWorker) setSink(s io.Writer) {
+	w.sinkMu.Lock()
+	w.sink = s
+	w.sinkMu.Unlock()
+}
+
+func (w *hostWorker) send(req map[string]any) error {
+	b, _ := json.Marshal(req)
+	_, err := w.stdin.Write(append(b, '\n'))
+	return err
+}
+
+// wait attend la trame id (les trames périmées sont ignorées).
+func (w *hostWorker) wait(id int64, timeout <-chan time.Time) (hostFrame, error) {
+	for {
+		select {
+		case f := <-w.frames:
+			if f.ID == id {
+				return f, nil
+			}
+		case <-w.done:
+			return hostFrame{}, errHostDied
+		case <-timeout:
+			return hostFrame{}, errors.New("timeout")
+		}
+	}
+}
+
+func (w *hostWorker) ping(timeout time.Duration) (hostFrame, error) {
+	id := w.seq.Add(1)
+	if err := w.send(map[string]any{"id": id, "op": "ping"}); err != nil {
+		return hostFrame{}, err
+	}
+	return w.wait(id, time.After(timeout))
+}
+
+// run exécute un script dans le worker; même contrat que runProcess (stdout, stderr, err).
+func (w *hostWorker) run(ctx context.Context, scriptPath string, inputJSON []byte, opts RunOpts) ([]byte, []byte, error) {
+	stderr := newTailBuffer()
+	pw := &progressWriter{onProgress: opts.OnProgress, rest: stderr}
+	w.setSink(pw)
+	out := newHeadBuffer()
+	start := time.Now()
+	exitCode := -1
+	defer func() {
+		w.setSink(nil)
+		pw.flush()
+		opts.Diag.record("pwsh-host", start, exitCode, out, stderr)
+	}()
+
+	// maxStdout: host.ps1 cesse d'accumuler au-delà (le total est renvoyé dans la trame)
+	req := map[string]any{"op": "run", "script": scriptPath, "inputJson": string(inputJSON), "maxStdout": out.max}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err // jamais envoyée au worker
+	}
+	var cancelFile string
+	jobEnv := opts.Env
+	if opts.Grace > 0 {
+		cancelFile = filepath.Join(os.TempDir(), fmt.Sprintf("openhvx-cancel-%d-%d", os.Getpid(), time.Now().UnixNano()))
+		defer os.Remove(cancelFile)
+		req["cancelFile"] = cancelFile
+		jobEnv = append(jobEnv[:len(jobEnv):len(jobEnv)], CancelFileEnv+"="+cancelFile)
+	}
+	if len(opts.Env) > 0 {
+		req["env"] = envMap(opts.Env)
+	}
+	opts.Diag.setEnv(scriptEnv(jobEnv), WorkDir())
+	id := w.seq.Add(1)
+	req["id"] = id
+	w.jobs++
+	if err := w.send(req); err != nil {
+		w.kill()
+		return nil, nil, fmt.Errorf("%w: %v", errHostDied, err)
+	}
+
+	ctxDone := ctx.Done()
+	var graceC, killedC <-chan time.Time
+	killNow := func() {
+		w.kill()
+		killedC = time.After(killWaitDelay)
+	}
+	for {
+		select {
+		case f := <-w.frames:
+			if f.ID != id {
+				continue
+			}
+			// Enregistrements STDERR du job (progression) tous reçus avant de rendre la main
+			endWait := time.After(2 * time.Second)
+		drain:
+			for {
+				select {
+				case end := <-w.ends:
+					if end == id {
+						break drain
+					}
+				case <-endWait:
+					break drain
+				}
+			}
+			_, _ = out.Write([]byte(f.Stdout))
+			if f.StdoutBytes > out.total {
+				out.total = f.StdoutBytes // tronqué côté host.ps1
+			}
+			exitCode = f.ExitCode
+			if err := ctx.Err(); err != nil {
+				return out.Bytes(), stderr.Bytes(), err
+			}
+			if f.ExitCode != 0 {
+				return out.Bytes(), stderr.Bytes(), fmt.Errorf("exit status %d", f.ExitCode)
+			}
+			if len(bytes.TrimSpace(out.Bytes())) == 0 {
+				return nil, nil, errors.New("empty action output")
+			}
+			if err := out.tooLarge(); err != nil {
+				return nil, stderr.Bytes(), err
+			}
+			return out.Bytes(), stderr.Bytes(), nil
+
+		case <-w.done:
+			if err := ctx.Err(); err != nil {
+				return nil, stderr.Bytes(), err
+			}
+			return nil, stderr.Bytes(), errHostDied
+
+		case <-ctxDone:
+			ctxDone = nil
+			// Échéance (ou pas de grâce): kill immédiat, le worker sera remplacé
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) || opts.Grace <= 0 {
+				killNow()
+				continue
+			}
+			_ = os.WriteFile(cancelFile, []byte(context.Cause(ctx).Error()), 0o644)
+			graceC = time.After(opts.Grace)
+
+		case <-graceC:
+			killNow()
+
+		case <-killedC:
+			return nil, stderr.Bytes(), ctx.Err()
+		}
+	}
+}
+
+func (w *hostWorker) alive() bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (w *hostWorker) pid() int {
+	if w.cmd.Process == nil {
+		return 0
+	}
+	return w.cmd.Process.Pid
+}
+
+func (w *hostWorker) kill() {
+	_ = killProcessTree(w.cmd.Process)
+}
+
+// shutdown demande la sortie du worker, puis le tue s'il ne répond pas.
+func (w *hostWorker) shutdown() {
+	if !w.alive() {
+		return
+	}
+	_ = w.send(map[string]any{"id": w.seq.Add(1), "op": "exit"})
+	_ = w.stdin.Close()
+	select {
+	case <-w.done:
+	case <-time.After(killWaitDelay):
+		w.kill()
+	}
+}
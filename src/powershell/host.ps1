@@ -0,0 +1,136 @@
+# powershell/host.ps1 — worker PowerShell persistant (pool de l'agent, voir host.go)
+#
+# Protocole (une ligne JSON par message, UTF-8):
+#   STDIN  <- { "id":1, "op":"ping" }
+#             { "id":2, "op":"run", "script":"C:\...\actions\vm.power.ps1", "inputJson":"{...}", "cancelFile":"...", "maxStdout":4194304,
+#               "env":{ "OPENHVX_TASK_ID":"...", ... } }
+#             { "id":3, "op":"exit" }
+#   STDOUT -> ##openhvx:frame { "id":0, "ok":true, "ready":true, "pid":1234, "workingSet":... }   (au démarrage)
+#             ##openhvx:frame { "id":1, "ok":true, "pid":1234, "workingSet":... }
+#             ##openhvx:frame { "id":2, "ok":true, "exitCode":0, "stdout":"...", "stdoutBytes":1234 }
+#   STDERR -> sortie d'erreur / progression du script, puis "##openhvx:end <id>" à la fin de chaque job.
+#
+# Les scripts sont exécutés dans ce process (& <script> -InputJson ...): les modules restent chargés
+# d'un job à l'autre. Seuls les scripts inputMode "InputJson" sans oneShot (voir <action>.manifest.json) passent par ici.
+
+$ErrorActionPreference = 'Continue'
+$ProgressPreference = 'SilentlyContinue'
+$WarningPreference = 'SilentlyContinue'
+
+$utf8 = [System.Text.UTF8Encoding]::new($false)
+$reader = [System.IO.StreamReader]::new([Console]::OpenStandardInput(), $utf8)
+$writer = [System.IO.StreamWriter]::new([Console]::OpenStandardOutput(), $utf8)
+$writer.AutoFlush = $true
+$homeDir = (Get-Location).Path
+
+function Send-Frame {
+  param([Parameter(Mandatory = $true)][hashtable]$Frame)
+  $writer.WriteLine("##openhvx:frame " + ($Frame | ConvertTo-Json -Compress -Depth 4))
+}
+
+function Get-WorkingSet {
+  [System.Diagnostics.Process]::GetCurrentProcess().WorkingSet64
+}
+
+# Sortie plafonnée: au-delà de $Max caractères on ne fait plus que compter (taille réelle -> stdoutBytes)
+function Add-Out {
+  param($Sb, [int]$Max, [string]$Line)
+  $script:outBytes += $utf8.GetByteCount($Line) + 1
+  $room = $Max - $Sb.Length
+  if ($room -le 0) { return }
+  if ($Line.Length + 1 -gt $room) { [void]$Sb.Append($Line.Substring(0, [Math]::Max(0, $room - 1))); return }
+  [void]$Sb.AppendLine($Line)
+}
+
+function Invoke-HostJob {
+  param([Parameter(Mandatory = $true)]$Req)
+  $sb = [System.Text.StringBuilder]::new()
+  $max = if ($Req.maxStdout) { [int]$Req.maxStdout } else { [int]::MaxValue }
+  $script:outBytes = 0
+  $exitCode = 0
+  $errMsg = $null
+
+  if ($Req.cancelFile) { $env:OPENHVX_CANCEL_FILE = [string]$Req.cancelFile } else { $env:OPENHVX_CANCEL_FILE = $null }
+  # Variables de la tâche (OPENHVX_TASK_ID, ...), retirées à la fin du job
+  $jobEnv = @()
+  if ($Req.env) {
+    foreach ($p in $Req.env.PSObject.Properties) {
+      [Environment]::SetEnvironmentVariable($p.Name, [string]$p.Value)
+      $jobEnv += $p.Name
+    }
+  }
+  $global:LASTEXITCODE = 0
+  # STDIN du process = protocole du worker: un script qui lit [Console]::In reçoit une entrée vide
+  $prevIn = [Console]::In
+  [Console]::SetIn([System.IO.StringReader]::new(''))
+  try {
+    & $Req.script -InputJson ([string]$Req.inputJson) *>&1 | ForEach-Object {
+      $rec = $_
+      if ($rec -is [System.Management.Automation.ErrorRecord]) {
+        [Console]::Error.WriteLine(($rec | Out-String).TrimEnd())
+      }
+      elseif ($rec -is [System.Management.Automation.WarningRecord] -or
+        $rec -is [System.Management.Automation.VerboseRecord] -or
+        $rec -is [System.Management.Automation.DebugRecord]) {
+        [Console]::Error.WriteLine($rec.Message)
+      }
+      elseif ($rec -is [System.Management.Automation.InformationRecord]) {
+        Add-Out $sb $max ([string]$rec.MessageData)
+      }
+      elseif ($rec -is [string]) {
+        Add-Out $sb $max $rec
+      }
+      else {
+        Add-Out $sb $max (($rec | Out-String).TrimEnd())
+      }
+    }
+    if ($LASTEXITCODE) { $exitCode = [int]$LASTEXITCODE }
+  }
+  catch {
+    # Erreur terminante non gérée par le script: équivalent d'un pwsh -File en échec
+    $errMsg = $_.Exception.Message
+    [Console]::Error.WriteLine($errMsg)
+    $exitCode = 1
+  }
+  finally {
+    [Console]::SetIn($prevIn)
+    $env:OPENHVX_CANCEL_FILE = $null
+    foreach ($n in $jobEnv) { [Environment]::SetEnvironmentVariable($n, $null) }
+    try { Set-Location -LiteralPath $homeDir } catch {}
+  }
+
+  $frame = @{ id = $Req.id; ok = ($exitCode -eq 0); exitCode = $exitCode; stdout = $sb.ToString(); stdoutBytes = $script:outBytes }
+  if ($errMsg) { $frame.error = $errMsg }
+  return $frame
+}
+
+# Modules coûteux chargés une seule fois (absent hors Hyper-V: ignoré)
+try { Import-Module Hyper-V -ErrorAction Stop | Out-Null } catch {}
+
+Send-Frame @{ id = 0; ok = $true; ready = $true; pid = $PID; workingSet = (Get-WorkingSet) }
+
+$running = $true
+while ($running) {
+  $line = $reader.ReadLine()
+  if ($null -eq $line) { break } # agent parti: STDIN fermé
+  if ([string]::IsNullOrWhiteSpace($line)) { continue }
+  try { $req = $line | ConvertFrom-Json -ErrorAction Stop } catch { continue }
+
+  switch ([string]$req.op) {
+    'ping' {
+      Send-Frame @{ id = $req.id; ok = $true; pid = $PID; workingSet = (Get-WorkingSet) }
+    }
+    'run' {
+      $frame = Invoke-HostJob -Req $req
+      [Console]::Error.WriteLine("##openhvx:end " + $req.id)
+      [Console]::Error.Flush()
+      Send-Frame $frame
+    }
+    'exit' {
+      $running = $false
+    }
+    default {
+      Send-Frame @{ id = $req.id; ok = $false; error = "unknown op '$($req.op)'" }
+    }
+  }
+}
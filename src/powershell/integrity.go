@@ -0,0 +1,289 @@
+package powershell
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Manifest d'intégrité signé (tools/integrity-sign), à la racine du dossier powershell:
+//
+//	integrity.json      { "v":1, "createdAt":"...", "files": { "actions/vm.power.ps1":"<sha256 hex>", "bin/...":"..." } }
+//	integrity.json.sig  signature Ed25519 (base64) des octets exacts de integrity.json
+const (
+	IntegrityFile    = "integrity.json"
+	IntegritySigFile = "integrity.json.sig"
+)
+
+// ErrIntegrity: fichier absent du manifest signé, modifié, ou manifest non signé par une clé de confiance.
+var ErrIntegrity = errors.New("integrity check failed")
+
+// IntegrityViolation décrit un fichier bloqué (publié en alerte par l'agent).
+type IntegrityViolation struct {
+	Action string `json:"action,omitempty"` // action bloquée ("" = contrôle au démarrage)
+	File   string `json:"file"`             // chemin relatif au dossier powershell
+	Reason string `json:"reason"`
+}
+
+// OnIntegrityViolation est appelé pour chaque violation (ex: alerte télémétrie).
+var OnIntegrityViolation func(IntegrityViolation)
+
+type integrityManifest struct {
+	V         int               `json:"v"`
+	CreatedAt string            `json:"createdAt"`
+	Files     map[string]string `json:"files"`
+}
+
+var (
+	integrityMu sync.RWMutex
+	trustedKeys []ed25519.PublicKey
+)
+
+// SetIntegrityKeys configure les clés publiques Ed25519 (base64) de confiance.
+// Sans clé, la vérification est désactivée.
+func SetIntegrityKeys(keys []string) error {
+	parsed := make([]ed25519.PublicKey, 0, len(keys))
+	for _, k := range keys {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 public key %q", k)
+		}
+		parsed = append(parsed, ed25519.PublicKey(b))
+	}
+	integrityMu.Lock()
+	trustedKeys = parsed
+	integrityMu.Unlock()
+	return nil
+}
+
+// IntegrityEnabled: au moins une clé de confiance est configurée.
+func IntegrityEnabled() bool {
+	integrityMu.RLock()
+	defer integrityMu.RUnlock()
+	return len(trustedKeys) > 0
+}
+
+// VerifyIntegrity contrôle tout le dossier powershell (démarrage): signature du manifest,
+// empreinte de chaque fichier listé, et host.ps1 / fichiers de actions/ ou bin/ non listés.
+func VerifyIntegrity() ([]IntegrityViolation, error) {
+	if !IntegrityEnabled() {
+		return nil, nil
+	}
+	root, err := resolveScript("powershell")
+	if err != nil {
+		return nil, err
+	}
+	man, err := loadIntegrityManifest(root)
+	if err != nil {
+		v := IntegrityViolation{File: IntegrityFile, Reason: err.Error()}
+		reportViolation(v)
+		return []IntegrityViolation{v}, nil
+	}
+
+	var out []IntegrityViolation
+	rels := make([]string, 0, len(man.Files))
+	for rel := range man.Files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	for _, rel := range rels {
+		if err := man.check(root, rel); err != nil {
+			out = append(out, IntegrityViolation{File: rel, Reason: err.Error()})
+		}
+	}
+	if _, ok := man.Files["host.ps1"]; !ok {
+		out = append(out, IntegrityViolation{File: "host.ps1", Reason: "not listed in signed manifest"})
+	}
+	for _, dir := range []string{"actions", "bin"} {
+		_ = filepath.WalkDir(filepath.Join(root, dir), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, p)
+			if _, ok := man.Files[filepath.ToSlash(rel)]; !ok {
+				out = append(out, IntegrityViolation{File: filepath.ToSlash(rel), Reason: "not listed in signed manifest"})
+			}
+			return nil
+		})
+	}
+	for _, v := range out {
+		reportViolation(v)
+	}
+	return out, nil
+}
+
+// checkIntegrity vérifie, avant exécution, les fichiers donnés (chemins absolus sous
+// powershell/) et les binaires de powershell/bin. Le manifest est relu à chaque appel.
+func checkIntegrity(action string, files ...string) error {
+	sums := make(map[string]string, len(files))
+	for _, f := range files {
+		sums[f] = "" // lu sur disque
+	}
+	return checkSums(action, sums)
+}
+
+// CheckBinary vérifie un exécutable externe (externalActions) avant son lancement: il doit
+// se trouver sous powershell/ et être listé dans integrity.json, comme les binaires de bin/.
+// Sans clé de confiance, rien n'est vérifié.
+func CheckBinary(action, path string) error {
+	if !IntegrityEnabled() {
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrIntegrity, path, err)
+	}
+	return checkIntegrity(action, abs)
+}
+
+// checkSums: comme checkIntegrity, pour des contenus déjà lus (chemin absolu -> sha256 hex;
+// "" = lire le fichier). Les binaires de powershell/bin sont lus sur disque.
+func checkSums(action string, sums map[string]string) error {
+	if !IntegrityEnabled() {
+		return nil
+	}
+	root, err := resolveScript("powershell")
+	if err != nil {
+		return err
+	}
+	fail := func(file, reason string) error {
+		reportViolation(IntegrityViolation{Action: action, File: file, Reason: reason})
+		return fmt.Errorf("%w: %s: %s", ErrIntegrity, file, reason)
+	}
+	man, err := loadIntegrityManifest(root)
+	if err != nil {
+		return fail(IntegrityFile, err.Error())
+	}
+
+	all := make(map[string]string, len(sums))
+	for f, sum := range sums {
+		all[f] = sum
+	}
+	bins, _ := os.ReadDir(filepath.Join(root, "bin"))
+	for _, e := range bins {
+		if !e.IsDir() {
+			all[filepath.Join(root, "bin", e.Name())] = ""
+		}
+	}
+	files := make([]string, 0, len(all))
+	for f := range all {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		rel, err := filepath.Rel(root, f)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fail(f, "outside the powershell directory")
+		}
+		rel = filepath.ToSlash(rel)
+		sum := all[f]
+		if sum == "" {
+			if sum, err = fileSHA256(f); err != nil {
+				return fail(rel, err.Error())
+			}
+		}
+		if err := man.match(rel, sum); err != nil {
+			return fail(rel, err.Error())
+		}
+	}
+	return nil
+}
+
+func reportViolation(v IntegrityViolation) {
+	log.Printf("[PS] integrity violation action=%q file=%s: %s", v.Action, v.File, v.Reason)
+	if OnIntegrityViolation != nil {
+		OnIntegrityViolation(v)
+	}
+}
+
+// loadIntegrityManifest lit integrity.json et vérifie sa signature contre les clés de confiance.
+func loadIntegrityManifest(root string) (*integrityManifest, error) {
+	body, err := os.ReadFile(filepath.Join(root, IntegrityFile))
+	if err != nil {
+		return nil, fmt.Errorf("signed manifest missing: %w", err)
+	}
+	sigB64, err := os.ReadFile(filepath.Join(root, IntegritySigFile))
+	if err != nil {
+		return nil, fmt.Errorf("signature missing: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigB64)))
+	if err != nil {
+		return nil, fmt.Errorf("signature unreadable: %w", err)
+	}
+
+	integrityMu.RLock()
+	keys := trustedKeys
+	integrityMu.RUnlock()
+	trusted := false
+	for _, k := range keys {
+		if ed25519.Verify(k, body, sig) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return nil, errors.New("signature does not match any trusted key")
+	}
+
+	var m integrityManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("signed manifest unreadable: %w", err)
+	}
+	return &m, nil
+}
+
+// check compare l'empreinte SHA-256 d'un fichier (relatif, séparateurs "/") au manifest.
+func (m *integrityManifest) check(root, rel string) error {
+	if _, ok := m.Files[rel]; !ok {
+		return errors.New("not listed in signed manifest")
+	}
+	got, err := fileSHA256(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	return m.match(rel, got)
+}
+
+// match compare une empreinte déjà calculée à celle du manifest.
+func (m *integrityManifest) match(rel, got string) error {
+	want, ok := m.Files[rel]
+	if !ok {
+		return errors.New("not listed in signed manifest")
+	}
+	if !strings.EqualFold(got, want) {
+		return errors.New("sha256 mismatch")
+	}
+	return nil
+}
+
+// bytesSHA256 renvoie l'empreinte SHA-256 (hex) d'un contenu.
+func bytesSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileSHA256 renvoie l'empreinte SHA-256 (hex) d'un fichier.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
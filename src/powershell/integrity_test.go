@@ -0,0 +1,74 @@
+package powershell
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// signTree écrit integrity.json (signé) sous root/powershell pour les fichiers donnés.
+func signTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := filepath.Join(root, "powershell")
+	sums := map[string]string{}
+	for rel, body := range files {
+		p := filepath.Join(ps, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		sums[rel] = bytesSHA256([]byte(body))
+	}
+	body, _ := json.Marshal(integrityManifest{V: 1, Files: sums})
+	if err := os.WriteFile(filepath.Join(ps, IntegrityFile), body, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, body))
+	if err := os.WriteFile(filepath.Join(ps, IntegritySigFile), []byte(sig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetIntegrityKeys([]string{base64.StdEncoding.EncodeToString(pub)}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = SetIntegrityKeys(nil) })
+}
+
+// Un exécutable externe est contrôlé comme un script: listé, intact, sous powershell/.
+func TestCheckBinary(t *testing.T) {
+	root := t.TempDir()
+	signTree(t, root, map[string]string{"bin/iso-tool.exe": "v1"})
+	t.Chdir(root)
+	outside := filepath.Join(t.TempDir(), "iso-tool.exe")
+	if err := os.WriteFile(outside, []byte("v1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var alerts []IntegrityViolation
+	OnIntegrityViolation = func(v IntegrityViolation) { alerts = append(alerts, v) }
+	t.Cleanup(func() { OnIntegrityViolation = nil })
+
+	if err := CheckBinary("iso.build", filepath.Join("powershell", "bin", "iso-tool.exe")); err != nil {
+		t.Fatalf("listed binary: %v", err)
+	}
+	if err := CheckBinary("iso.build", outside); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("binary outside powershell/: err = %v, want ErrIntegrity", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "powershell", "bin", "iso-tool.exe"), []byte("v2"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBinary("iso.build", filepath.Join(root, "powershell", "bin", "iso-tool.exe")); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("modified binary: err = %v, want ErrIntegrity", err)
+	}
+	if len(alerts) != 2 || alerts[1].Action != "iso.build" || alerts[1].File != "bin/iso-tool.exe" {
+		t.Errorf("alerts = %+v, want 2 (last on bin/iso-tool.exe)", alerts)
+	}
+}
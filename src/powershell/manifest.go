@@ -0,0 +1,197 @@
+package powershell
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ManifestSuffix: fichier décrivant un script d'action (<action>.manifest.json).
+const ManifestSuffix = ".manifest.json"
+
+// Modes de passage de l'entrée au script.
+const (
+	InputModeJSON  = "InputJson" // -InputJson '<data>' (worker persistant possible)
+	InputModeStdin = "stdin"     // { action, data } sur STDIN, toujours en pwsh -File
+)
+
+// ErrNoManifest: le script existe peut-être, mais sans manifest il n'est pas exécuté.
+var ErrNoManifest = errors.New("no action manifest")
+
+// Manifest décrit le contrat d'un script d'action.
+type Manifest struct {
+	Name        string `json:"name"`                  // = nom de l'action (et du fichier)
+	Version     string `json:"version"`               // version du script, publiée dans le heartbeat
+	Capability  string `json:"capability"`            // capability qui active l'action (défaut: name)
+	TimeoutSec  int    `json:"timeoutSec,omitempty"`  // délai par défaut (surchargé par actionTimeoutsSec)
+	Mutating    bool   `json:"mutating"`              // false = lecture seule (jamais sérialisée par VM)
+	InputMode   string `json:"inputMode"`             // InputJson | stdin
+	InputSchema string `json:"inputSchema,omitempty"` // schéma JSON de data, relatif au dossier actions
+	OneShot     bool   `json:"oneShot,omitempty"`     // jamais dans un worker persistant
+	DryRun      bool   `json:"supportsDryRun"`        // honore __ctx.dryRun (plan sans effet de bord)
+	Description string `json:"description,omitempty"`
+
+	script string // chemin résolu du .ps1
+	dir    string
+	body   []byte            // contenu du .ps1 au chargement: c'est lui qui est exécuté (voir snapshotScript)
+	schema []byte            // schéma d'entrée lu au chargement (nil si aucun)
+	sums   map[string]string // chemin -> sha256 des fichiers lus (script, manifest, schéma), pour l'intégrité
+	sum    string            // empreinte script + manifest + schéma (détection des mises à jour, voir WatchCatalog)
+}
+
+// Script renvoie le chemin du .ps1 décrit.
+func (m *Manifest) Script() string { return m.script }
+
+// Schema renvoie le schéma d'entrée tel que chargé avec le manifest (ErrNoSchema si aucun).
+func (m *Manifest) Schema() ([]byte, error) {
+	if m.schema == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSchema, m.Name)
+	}
+	return m.schema, nil
+}
+
+// Verify contrôle l'entrée du catalogue (script, manifest et schéma tels que chargés)
+// contre integrity.json signé. Appelé à l'admission d'une tâche: la version admise reste
+// exécutable même si integrity.json est mis à jour avec une nouvelle version ensuite.
+func (m *Manifest) Verify() error {
+	return checkSums(m.Name, m.sums)
+}
+
+// Catalog: manifests chargés depuis powershell/actions.
+type Catalog struct {
+	Dir     string
+	Actions map[string]*Manifest
+}
+
+var (
+	catalogMu sync.RWMutex
+	catalog   *Catalog
+)
+
+// LoadCatalog (re)charge les manifests et remplace le catalogue courant (atomiquement:
+// un manifest déjà résolu est un instantané immuable, schéma et empreinte du script compris). Un manifest invalide est ignoré (loggé);
+// seule l'absence du dossier actions est une erreur.
+func LoadCatalog() (*Catalog, error) {
+	c, err := readCatalog()
+	if err != nil {
+		return nil, err
+	}
+	catalogMu.Lock()
+	catalog = c
+	catalogMu.Unlock()
+	pruneSnapshots(c.Dir)
+	return c, nil
+}
+
+// CurrentCatalog renvoie le catalogue (chargé au premier appel si besoin).
+func CurrentCatalog() *Catalog {
+	catalogMu.RLock()
+	c := catalog
+	catalogMu.RUnlock()
+	if c != nil {
+		return c
+	}
+	c, err := LoadCatalog()
+	if err != nil {
+		log.Printf("[PS] manifests: %v", err)
+		return &Catalog{Actions: map[string]*Manifest{}}
+	}
+	return c
+}
+
+// ActionManifest renvoie le manifest d'une action.
+func ActionManifest(action string) (*Manifest, bool) {
+	m, ok := CurrentCatalog().Actions[action]
+	return m, ok
+}
+
+// Names: actions décrites, triées.
+func (c *Catalog) Names() []string {
+	out := make([]string, 0, len(c.Actions))
+	for a := range c.Actions {
+		out = append(out, a)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func readCatalog() (*Catalog, error) {
+	dir, err := resolveScript(filepath.Join("powershell", "actions"))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{Dir: dir, Actions: map[string]*Manifest{}}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ManifestSuffix) || strings.HasPrefix(name, "_") {
+			continue
+		}
+		m, err := readManifest(dir, name)
+		if err != nil {
+			log.Printf("[PS] manifest %s ignored: %v", name, err)
+			continue
+		}
+		c.Actions[m.Name] = m
+	}
+	return c, nil
+}
+
+func readManifest(dir, file string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	action := strings.TrimSuffix(file, ManifestSuffix)
+	if m.Name != action {
+		return nil, fmt.Errorf("name %q does not match file name", m.Name)
+	}
+	if safeActionName(m.Name) != m.Name {
+		return nil, fmt.Errorf("invalid action name %q", m.Name)
+	}
+	if m.Capability == "" {
+		m.Capability = m.Name
+	}
+	switch m.InputMode {
+	case InputModeJSON, InputModeStdin:
+	case "":
+		m.InputMode = InputModeJSON
+	default:
+		return nil, fmt.Errorf("unknown inputMode %q (use %s|%s)", m.InputMode, InputModeJSON, InputModeStdin)
+	}
+	m.dir = dir
+	m.script = filepath.Join(dir, m.Name+".ps1")
+	body, err := os.ReadFile(m.script)
+	if err != nil {
+		return nil, fmt.Errorf("script missing: %w", err)
+	}
+	m.body = body
+	m.sums = map[string]string{m.script: bytesSHA256(body), filepath.Join(dir, file): bytesSHA256(b)}
+	m.sum = m.sums[m.script] + ":" + m.sums[filepath.Join(dir, file)]
+	if m.InputSchema != "" {
+		if filepath.IsAbs(m.InputSchema) || strings.Contains(filepath.ToSlash(m.InputSchema), "..") {
+			return nil, fmt.Errorf("inputSchema must be relative to the actions directory")
+		}
+		sb, err := os.ReadFile(filepath.Join(dir, m.InputSchema))
+		if err != nil {
+			return nil, fmt.Errorf("inputSchema: %w", err)
+		}
+		m.schema = sb
+		m.sums[filepath.Join(dir, m.InputSchema)] = bytesSHA256(sb)
+		m.sum += ":" + m.sums[filepath.Join(dir, m.InputSchema)]
+	}
+	return &m, nil
+}
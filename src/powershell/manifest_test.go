@@ -0,0 +1,114 @@
+package powershell
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeAction crée actions/vm.test.{ps1,schema.json,manifest.json} sous root.
+func writeAction(t *testing.T, root, script, schema string) string {
+	t.Helper()
+	dir := filepath.Join(root, "actions")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{
+		"vm.test.ps1":              script,
+		"vm.test.schema.json":      schema,
+		"vm.test" + ManifestSuffix: `{"name":"vm.test","version":"1.0.0","mutating":true,"oneShot":true,"inputSchema":"vm.test.schema.json"}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Une entrée du catalogue est un instantané: schéma et contenu du script sont ceux du chargement.
+func TestManifestSnapshot(t *testing.T) {
+	root := t.TempDir()
+	dir := writeAction(t, root, `{"version":"v1"}`, `{"type":"object"}`)
+	m, err := readManifest(dir, "vm.test"+ManifestSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.InputMode != InputModeJSON || m.Capability != "vm.test" {
+		t.Errorf("defaults: inputMode=%q capability=%q", m.InputMode, m.Capability)
+	}
+
+	writeAction(t, root, `{"version":"v2"}`, `{"type":"string"}`)
+	if s, err := m.Schema(); err != nil || string(s) != `{"type":"object"}` {
+		t.Errorf("Schema = %s, %v; want the schema loaded with the manifest", s, err)
+	}
+	path, err := m.snapshotScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != filepath.Join(root, SnapshotDir) {
+		t.Errorf("snapshot %s not in %s (scripts resolve ..\\bin from $PSScriptRoot)", path, SnapshotDir)
+	}
+	if b, _ := os.ReadFile(path); string(b) != `{"version":"v1"}` {
+		t.Errorf("snapshot = %s, want the v1 body", b)
+	}
+
+	// Copie altérée: réécrite depuis le contenu chargé
+	if err := os.WriteFile(path, []byte("tampered"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := m.snapshotScript(); err != nil || again != path {
+		t.Fatalf("snapshotScript = %s, %v", again, err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != `{"version":"v1"}` {
+		t.Errorf("snapshot not restored: %s", b)
+	}
+
+	next, err := readManifest(dir, "vm.test"+ManifestSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch := diffCatalogs(&Catalog{Actions: map[string]*Manifest{"vm.test": m}}, &Catalog{Actions: map[string]*Manifest{"vm.test": next}}); len(ch.Updated) != 1 {
+		t.Errorf("diff = %s, want vm.test updated", ch)
+	}
+}
+
+// Le .ps1 est remplacé entre l'admission et l'exécution: c'est l'ancien contenu qui tourne.
+func TestRunActionScriptUsesAdmittedVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake pwsh is a shell script")
+	}
+	// pwsh factice: affiche le fichier passé à -File
+	bin := t.TempDir()
+	fake := "#!/bin/sh\nwhile [ $# -gt 0 ]; do [ \"$1\" = \"-File\" ] && cat \"$2\"; shift; done\n"
+	if err := os.WriteFile(filepath.Join(bin, "pwsh"), []byte(fake), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	root := t.TempDir()
+	dir := writeAction(t, root, `{"version":"v1"}`, `{"type":"object"}`)
+	admitted, err := readManifest(dir, "vm.test"+ManifestSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAction(t, root, `{"version":"v2"}`, `{"type":"object"}`)
+
+	out, err := RunActionScriptOpts(context.Background(), "vm.test", map[string]any{}, RunOpts{Manifest: admitted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != `{"version":"v1"}` {
+		t.Errorf("ran %s, want the admitted v1 body", got)
+	}
+}
+
+func TestManifestWithoutSchema(t *testing.T) {
+	m := &Manifest{Name: "vm.power"}
+	if _, err := m.Schema(); !errors.Is(err, ErrNoSchema) {
+		t.Errorf("err = %v, want ErrNoSchema", err)
+	}
+}
@@ -0,0 +1,153 @@
+package powershell
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Plafonds de capture par exécution (SetOutputLimits): STDOUT garde le début,
+// STDERR garde la fin (les dernières lignes expliquent l'échec).
+var (
+	limitsMu  sync.RWMutex
+	maxStdout = 4 << 20
+	maxStderr = 16 << 10
+)
+
+// SetOutputLimits fixe les plafonds STDOUT / STDERR en octets (<= 0: inchangé).
+func SetOutputLimits(stdoutBytes, stderrBytes int) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	if stdoutBytes > 0 {
+		maxStdout = stdoutBytes
+	}
+	if stderrBytes > 0 {
+		maxStderr = stderrBytes
+	}
+}
+
+func outputLimits() (int, int) {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	return maxStdout, maxStderr
+}
+
+// Diagnostics: bloc publié avec chaque résultat de tâche (résultat.diagnostics).
+type Diagnostics struct {
+	Runner          string   `json:"runner,omitempty"` // pwsh | pwsh-host | process | native
+	ExitCode        int      `json:"exitCode"`         // -1: pas de code de sortie (non lancé, tué)
+	DurationMs      int64    `json:"durationMs"`
+	StdoutBytes     int64    `json:"stdoutBytes"`
+	StdoutTruncated bool     `json:"stdoutTruncated,omitempty"`
+	StderrBytes     int64    `json:"stderrBytes"`
+	StderrTruncated bool     `json:"stderrTruncated,omitempty"`
+	StderrTail      string   `json:"stderrTail,omitempty"` // fin de STDERR (hors progression), plafonnée
+	WorkDir         string   `json:"workDir,omitempty"`    // dossier de travail du script
+	Env             []string `json:"env,omitempty"`        // noms des variables transmises (jamais les valeurs)
+}
+
+// setEnv renseigne l'environnement effectif d'une exécution (d peut être nil).
+func (d *Diagnostics) setEnv(env []string, dir string) {
+	if d == nil {
+		return
+	}
+	d.Env = envNames(env)
+	d.WorkDir = dir
+}
+
+// record renseigne les diagnostics d'une exécution (d peut être nil).
+func (d *Diagnostics) record(runner string, start time.Time, exitCode int, out *headBuffer, stderr *tailBuffer) {
+	if d == nil {
+		return
+	}
+	d.Runner = runner
+	d.ExitCode = exitCode
+	d.DurationMs = time.Since(start).Milliseconds()
+	d.StdoutBytes = out.total
+	d.StdoutTruncated = out.truncated()
+	d.StderrBytes = stderr.total
+	d.StderrTruncated = stderr.truncated()
+	d.StderrTail = strings.TrimSpace(string(stderr.Bytes()))
+}
+
+// exitCodeOf: code de sortie du process, -1 s'il n'a pas démarré ou a été tué.
+func exitCodeOf(ps *os.ProcessState) int {
+	if ps == nil {
+		return -1
+	}
+	return ps.ExitCode()
+}
+
+// headBuffer conserve les max premiers octets et compte le reste.
+type headBuffer struct {
+	max   int
+	buf   bytes.Buffer
+	total int64
+}
+
+func newHeadBuffer() *headBuffer {
+	n, _ := outputLimits()
+	return &headBuffer{max: n}
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+func (b *headBuffer) Len() int { return b.buf.Len() }
+
+func (b *headBuffer) truncated() bool { return b.total > int64(b.buf.Len()) }
+
+// Bytes renvoie le contenu conservé, sans marqueur: la troncature est signalée par
+// ErrOutputTooLarge et dans les diagnostics (stdoutTruncated, stdoutBytes).
+func (b *headBuffer) Bytes() []byte { return b.buf.Bytes() }
+
+// tooLarge: erreur à renvoyer si STDOUT a dépassé le plafond (nil sinon).
+func (b *headBuffer) tooLarge() error {
+	if !b.truncated() {
+		return nil
+	}
+	return fmt.Errorf("%w: %d bytes (limit %d, see scriptStdoutMaxKB)", ErrOutputTooLarge, b.total, b.max)
+}
+
+// tailBuffer conserve les max derniers octets et compte le reste.
+type tailBuffer struct {
+	max   int
+	buf   []byte
+	total int64
+}
+
+func newTailBuffer() *tailBuffer {
+	_, n := outputLimits()
+	return &tailBuffer{max: n}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > 2*b.max {
+		b.buf = append(b.buf[:0:0], b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) truncated() bool { return b.total > int64(min(len(b.buf), b.max)) }
+
+// Bytes renvoie la fin conservée, précédée d'un marqueur si le début a été coupé.
+func (b *tailBuffer) Bytes() []byte {
+	kept := b.buf
+	if len(kept) > b.max {
+		kept = kept[len(kept)-b.max:]
+	}
+	if !b.truncated() {
+		return kept
+	}
+	return append([]byte(fmt.Sprintf("[truncated %d bytes]...\n", b.total-int64(len(kept)))), kept...)
+}
@@ -0,0 +1,29 @@
+package powershell
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+		HideWindow:    true,
+	}
+}
+
+// killProcessTree tue le processus et tous ses descendants (taskkill /T).
+// Repli sur Process.Kill si taskkill est indisponible ou échoue.
+func killProcessTree(p *os.Process) error {
+	if p == nil {
+		return nil
+	}
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid))
+	kill.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	if err := kill.Run(); err != nil {
+		return p.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package powershell
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+// ProgressPrefix préfixe, sur STDERR, une ligne de progression NDJSON émise par un script:
+//
+//	##openhvx:progress {"percent":40,"step":"copy-vhdx","message":"copying base image"}
+//
+// Côté PowerShell: [Console]::Error.WriteLine("##openhvx:progress " + ($rec | ConvertTo-Json -Compress))
+const ProgressPrefix = "##openhvx:progress "
+
+// Progress est un enregistrement d'avancement émis par un script d'action.
+type Progress struct {
+	Percent float64 `json:"percent"`
+	Step    string  `json:"step,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// progressWriter découpe STDERR en lignes: les lignes de progression sont décodées
+// et remontées au fil de l'eau, les autres sont conservées telles quelles dans rest.
+type progressWriter struct {
+	onProgress func(Progress)
+	rest       io.Writer
+	partial    []byte
+	overlong   bool // ligne en cours plus longue que le plafond STDERR: transmise telle quelle
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+	}
+	// Pas de fin de ligne au-delà du plafond STDERR: vidée dans rest (jamais une progression)
+	if _, max := outputLimits(); len(w.partial) > max {
+		w.rest.Write(w.partial)
+		w.partial = nil
+		w.overlong = true
+	}
+	return len(p), nil
+}
+
+// flush traite une éventuelle dernière ligne sans retour chariot.
+func (w *progressWriter) flush() {
+	if len(w.partial) > 0 {
+		w.line(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *progressWriter) line(l []byte) {
+	trimmed := bytes.TrimRight(l, "\r\n")
+	if w.overlong || !bytes.HasPrefix(trimmed, []byte(ProgressPrefix)) {
+		w.overlong = false
+		w.rest.Write(l)
+		return
+	}
+	var p Progress
+	if err := json.Unmarshal(trimmed[len(ProgressPrefix):], &p); err != nil {
+		log.Printf("[PS] invalid progress record: %v", err)
+		return
+	}
+	if w.onProgress != nil {
+		w.onProgress(p)
+	}
+}
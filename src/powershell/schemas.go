@@ -0,0 +1,41 @@
+package powershell
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoSchema: l'action n'a pas de schéma (pas de validation côté agent).
+var ErrNoSchema = errors.New("no input schema")
+
+// LoadActionSchema renvoie le schéma d'entrée déclaré par le manifest (inputSchema).
+// Sans manifest (action native / externe): <action>.schema.json s'il existe.
+func LoadActionSchema(action string) ([]byte, error) {
+	if m, ok := ActionManifest(action); ok {
+		return m.Schema()
+	}
+	p, err := resolveScript(filepath.Join("powershell", "actions", safeActionName(action)+".schema.json"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSchema, action)
+	}
+	return os.ReadFile(p)
+}
+
+// ActionSchemas renvoie les schémas d'entrée de toutes les actions décrites.
+func ActionSchemas() (map[string]json.RawMessage, error) {
+	out := map[string]json.RawMessage{}
+	for name, m := range CurrentCatalog().Actions {
+		b, err := m.Schema()
+		if err != nil {
+			continue
+		}
+		if !json.Valid(b) {
+			return nil, fmt.Errorf("invalid JSON in %s", m.InputSchema)
+		}
+		out[name] = b
+	}
+	return out, nil
+}
@@ -0,0 +1,80 @@
+package powershell
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"openhvx-agent/schema"
+)
+
+func TestActionSchemasParse(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("actions", "*.schema.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no schema found: %v", err)
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := schema.Parse(b); err != nil {
+			t.Errorf("%s: %v", f, err)
+		}
+	}
+}
+
+// Valeurs que les scripts acceptaient avant la validation côté agent: elles doivent
+// rester valides (vm.create.ps1 convertit avec [int], vm.power.ps1 met state en minuscules).
+func TestActionSchemaFields(t *testing.T) {
+	cases := []struct {
+		action, field string
+		value         any
+		ok            bool
+	}{
+		{"vm.create", "generation", float64(1), true},
+		{"vm.create", "generation", float64(2), true},
+		{"vm.create", "generation", "2", true},
+		{"vm.create", "generation", " 1 ", true},
+		{"vm.create", "generation", nil, true},
+		{"vm.create", "generation", float64(3), false},
+		{"vm.create", "generation", "3", false},
+		{"vm.create", "generation", "two", false},
+		{"vm.create", "generation", float64(1.5), false},
+		{"vm.create", "generation", true, false},
+		{"vm.create", "cpu", float64(4), true},
+		{"vm.create", "cpu", "4", true},
+		{"vm.create", "cpu", nil, true},
+		{"vm.create", "cpu", float64(0), false},
+		{"vm.create", "cpu", "four", false},
+		{"vm.power", "state", "on", true},
+		{"vm.power", "state", "On", true},
+		{"vm.power", "state", "OFF", true},
+		{"vm.power", "state", "Restart", true},
+		{"vm.power", "state", "PowerOn", true},
+		{"vm.power", "state", "reset", false},
+		{"vm.power", "state", "on ", false},
+		{"vm.power", "state", float64(1), false},
+	}
+	schemas := map[string]*schema.Schema{}
+	for _, c := range cases {
+		s, ok := schemas[c.action]
+		if !ok {
+			b, err := os.ReadFile(filepath.Join("actions", c.action+".schema.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s, err = schema.Parse(b); err != nil {
+				t.Fatal(err)
+			}
+			schemas[c.action] = s
+		}
+		prop, ok := s.Properties[c.field]
+		if !ok {
+			t.Fatalf("%s: no property %s", c.action, c.field)
+		}
+		if errs := prop.Validate(c.value); (len(errs) == 0) != c.ok {
+			t.Errorf("%s %s=%#v: errs = %v, want ok=%v", c.action, c.field, c.value, errs, c.ok)
+		}
+	}
+}
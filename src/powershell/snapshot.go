@@ -0,0 +1,77 @@
+package powershell
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SnapshotDir: copies versionnées des scripts exécutés, sous le dossier powershell (même
+// profondeur que actions/: $PSScriptRoot\..\bin reste valable pour les scripts).
+const SnapshotDir = ".snapshots"
+
+// Durée de conservation d'une copie inutilisée (rafraîchie à chaque exécution).
+const snapshotRetention = 24 * time.Hour
+
+// snapshotScript écrit (ou réutilise) la copie du script tel que chargé avec le manifest
+// et renvoie son chemin. La copie est relue et son empreinte comparée au contenu chargé
+// (celui vérifié à l'admission): c'est ce fichier, et non actions/<action>.ps1, que pwsh
+// exécute, de sorte qu'un script remplacé entre-temps n'est pas pris en compte.
+func (m *Manifest) snapshotScript() (string, error) {
+	dir := filepath.Join(filepath.Dir(m.dir), SnapshotDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("script snapshot: %w", err)
+	}
+	want := m.sums[m.script]
+	path := filepath.Join(dir, fmt.Sprintf("%s.%s.ps1", m.Name, want[:16]))
+	if got, err := fileSHA256(path); err == nil && got == want {
+		now := time.Now()
+		_ = os.Chtimes(path, now, now) // encore utilisée: pas de purge
+		return path, nil
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("script snapshot: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer func() { _ = os.Remove(tmpPath) }()
+	if _, err := tmp.Write(m.body); err != nil {
+		_ = tmp.Close()
+		return "", fmt.Errorf("script snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("script snapshot: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", fmt.Errorf("script snapshot: %w", err)
+	}
+	if got, err := fileSHA256(path); err != nil || got != want {
+		reportViolation(IntegrityViolation{Action: m.Name, File: filepath.ToSlash(filepath.Join(SnapshotDir, filepath.Base(path))), Reason: "snapshot altered"})
+		return "", fmt.Errorf("%w: %s: snapshot altered", ErrIntegrity, m.Name)
+	}
+	return path, nil
+}
+
+// pruneSnapshots supprime les copies inutilisées depuis snapshotRetention.
+func pruneSnapshots(actionsDir string) {
+	dir := filepath.Join(filepath.Dir(actionsDir), SnapshotDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-snapshotRetention)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".ps1") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				log.Printf("[PS] snapshot %s: %v", e.Name(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package powershell
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CatalogChange: différences entre deux catalogues (noms d'actions triés).
+type CatalogChange struct {
+	Added   []string
+	Removed []string
+	Updated []string // version ou contenu du script / manifest modifié
+}
+
+// Empty: rien n'a changé côté actions.
+func (c CatalogChange) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Updated) == 0
+}
+
+func (c CatalogChange) String() string {
+	return fmt.Sprintf("added=%v removed=%v updated=%v", c.Added, c.Removed, c.Updated)
+}
+
+var (
+	watchMu   sync.Mutex
+	watchStop chan struct{}
+)
+
+// WatchCatalog surveille powershell/actions (polling) et recharge le catalogue quand
+// le dossier a changé puis est resté stable un intervalle (copie terminée).
+// Le remplacement est atomique: une tâche garde l'entrée du catalogue (manifest, schéma,
+// empreinte du script) prise à son admission (voir RunOpts.Manifest). onChange est appelé après chaque rechargement effectif.
+func WatchCatalog(every time.Duration, onChange func(*Catalog, CatalogChange)) {
+	if every <= 0 {
+		return
+	}
+	watchMu.Lock()
+	if watchStop != nil {
+		watchMu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	watchStop = stop
+	watchMu.Unlock()
+
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		last := actionsFingerprint()
+		pending := ""
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+			fp := actionsFingerprint()
+			if fp == last {
+				pending = ""
+				continue
+			}
+			if fp != pending {
+				pending = fp // encore en cours d'écriture ? on attend un tour
+				continue
+			}
+			prev := CurrentCatalog()
+			c, err := LoadCatalog()
+			if err != nil {
+				log.Printf("[PS] actions reload failed: %v", err)
+				continue
+			}
+			last, pending = fp, ""
+			change := diffCatalogs(prev, c)
+			log.Printf("[PS] actions reloaded | %d actions | %s", len(c.Actions), change)
+			if onChange != nil {
+				onChange(c, change)
+			}
+		}
+	}()
+}
+
+// StopWatchCatalog arrête la surveillance du dossier actions.
+func StopWatchCatalog() {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if watchStop != nil {
+		close(watchStop)
+		watchStop = nil
+	}
+}
+
+// actionsFingerprint: nom, taille et date de chaque fichier du dossier actions.
+func actionsFingerprint() string {
+	dir, err := resolveScript(filepath.Join("powershell", "actions"))
+	if err != nil {
+		return ""
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() {
+			continue
+		}
+		fmt.Fprintf(&b, "%s|%d|%d\n", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+func diffCatalogs(prev, next *Catalog) CatalogChange {
+	var ch CatalogChange
+	for name, m := range next.Actions {
+		old, ok := prev.Actions[name]
+		switch {
+		case !ok:
+			ch.Added = append(ch.Added, name)
+		case old.Version != m.Version || old.sum != m.sum:
+			ch.Updated = append(ch.Updated, name)
+		}
+	}
+	for name := range prev.Actions {
+		if _, ok := next.Actions[name]; !ok {
+			ch.Removed = append(ch.Removed, name)
+		}
+	}
+	sort.Strings(ch.Added)
+	sort.Strings(ch.Removed)
+	sort.Strings(ch.Updated)
+	return ch
+}
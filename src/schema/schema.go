@@ -0,0 +1,307 @@
+// Package schema valide des payloads de tâches contre un sous-ensemble de
+// JSON Schema (type, required, properties, additionalProperties, items, enum,
+// minimum/maximum, minLength/maxLength, pattern, anyOf).
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema: un noeud de schéma. `true`/`false` sont acceptés partout où un schéma l'est.
+type Schema struct {
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 types              `json:"type,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+
+	never bool           // schéma `false`
+	re    *regexp.Regexp // Pattern compilé
+}
+
+// Error: une violation, localisée par un JSON pointer (RFC 6901, "" = racine).
+type Error struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	p := e.Pointer
+	if p == "" {
+		p = "(root)"
+	}
+	return p + ": " + e.Message
+}
+
+// Parse décode un schéma et compile ses patterns.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate renvoie toutes les violations (nil si v est conforme).
+// v est une valeur issue de encoding/json (map[string]any, []any, float64, ...).
+func (s *Schema) Validate(v any) []Error {
+	var errs []Error
+	s.validate(v, "", &errs)
+	return errs
+}
+
+// Join résume une liste d'erreurs sur une ligne.
+func Join(errs []Error) string {
+	parts := make([]string, len(errs))
+	for i, e := range errs {
+		parts[i] = e.Error()
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{never: true}
+		return nil
+	}
+	type plain Schema
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*s = Schema(p)
+	return nil
+}
+
+func (s *Schema) compile(at string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("schema %s: invalid pattern: %w", orRoot(at), err)
+		}
+		s.re = re
+	}
+	for k, p := range s.Properties {
+		if err := p.compile(at + "/properties/" + escape(k)); err != nil {
+			return err
+		}
+	}
+	if err := s.AdditionalProperties.compile(at + "/additionalProperties"); err != nil {
+		return err
+	}
+	if err := s.Items.compile(at + "/items"); err != nil {
+		return err
+	}
+	for i, a := range s.AnyOf {
+		if err := a.compile(at + "/anyOf/" + strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validate(v any, ptr string, errs *[]Error) {
+	if s == nil {
+		return
+	}
+	add := func(format string, args ...any) {
+		*errs = append(*errs, Error{Pointer: ptr, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.never {
+		add("is not allowed")
+		return
+	}
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		add("must be %s, got %s", s.Type, typeOf(v))
+		return // les autres mots-clés n'ont pas de sens sur le mauvais type
+	}
+	if len(s.Enum) > 0 && !inEnum(v, s.Enum) {
+		add("must be one of %s", enumString(s.Enum))
+	}
+
+	switch x := v.(type) {
+	case map[string]any:
+		for _, k := range s.Required {
+			if _, ok := x[k]; !ok {
+				*errs = append(*errs, Error{Pointer: ptr + "/" + escape(k), Message: "is required"})
+			}
+		}
+		for _, k := range sortedKeys(x) {
+			if p, ok := s.Properties[k]; ok {
+				p.validate(x[k], ptr+"/"+escape(k), errs)
+			} else if s.AdditionalProperties != nil {
+				if s.AdditionalProperties.never {
+					*errs = append(*errs, Error{Pointer: ptr + "/" + escape(k), Message: "unknown property"})
+				} else {
+					s.AdditionalProperties.validate(x[k], ptr+"/"+escape(k), errs)
+				}
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, it := range x {
+				s.Items.validate(it, ptr+"/"+strconv.Itoa(i), errs)
+			}
+		}
+	case string:
+		n := len([]rune(x))
+		if s.MinLength != nil && n < *s.MinLength {
+			add("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			add("must be at most %d characters", *s.MaxLength)
+		}
+		if s.re != nil && !s.re.MatchString(x) {
+			add("must match pattern %q", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && x < *s.Minimum {
+			add("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && x > *s.Maximum {
+			add("must be <= %v", *s.Maximum)
+		}
+	}
+
+	if len(s.AnyOf) > 0 {
+		var alts []string
+		for _, a := range s.AnyOf {
+			sub := a.Validate(v)
+			if len(sub) == 0 {
+				alts = nil
+				break
+			}
+			alts = append(alts, relative(sub[0], ptr))
+		}
+		if alts != nil {
+			add("must satisfy one of: %s", strings.Join(alts, " | "))
+		}
+	}
+}
+
+// types: "type" accepte une chaîne ou une liste.
+type types []string
+
+func (t *types) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = types{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("type: expected string or array of strings")
+	}
+	*t = many
+	return nil
+}
+
+func (t types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t types) String() string { return strings.Join(t, " or ") }
+
+func (t types) match(v any) bool {
+	for _, want := range t {
+		got := typeOf(v)
+		if got == want || (want == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if x == math.Trunc(x) && !math.IsInf(x, 0) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func inEnum(v any, enum []any) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumString(enum []any) string {
+	b, _ := json.Marshal(enum)
+	return string(b)
+}
+
+// relative: message d'une branche anyOf, avec le chemin relatif au noeud courant.
+func relative(e Error, base string) string {
+	rel := strings.TrimPrefix(e.Pointer, base)
+	if rel == "" {
+		return e.Message
+	}
+	return rel + " " + e.Message
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escape(k string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+}
+
+func orRoot(p string) string {
+	if p == "" {
+		return "(root)"
+	}
+	return p
+}
@@ -0,0 +1,103 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const vmSchema = `{
+  "type": "object",
+  "required": ["name", "cpu"],
+  "additionalProperties": false,
+  "properties": {
+    "name":  { "type": "string", "minLength": 1, "maxLength": 15, "pattern": "^[A-Za-z0-9-]+$" },
+    "cpu":   { "type": "integer", "minimum": 1, "maximum": 64 },
+    "ratio": { "type": "number" },
+    "state": { "enum": ["on", "off"] },
+    "tags":  { "type": "array", "items": { "type": "string" } },
+    "gen":   { "type": ["integer", "string"] },
+    "disk":  { "anyOf": [ { "type": "string" }, { "type": "object", "required": ["path"] } ] },
+    "meta":  { "type": "object", "additionalProperties": { "type": "string" } },
+    "a/b":   false,
+    "any":   true
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(vmSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		payload string
+		want    []Error
+	}{
+		{"valid", `{"name":"web-01","cpu":4,"ratio":0.5,"state":"on","tags":["a"],"gen":2,"disk":{"path":"x"},"meta":{"k":"v"},"any":[1]}`, nil},
+		{"integer as float", `{"name":"w","cpu":2.0}`, nil},
+		{"type list", `{"name":"w","cpu":1,"gen":"2"}`, nil},
+		{"required", `{}`, []Error{{"/name", "is required"}, {"/cpu", "is required"}}},
+		{"wrong type", `{"name":1,"cpu":"4"}`, []Error{
+			{"/cpu", "must be integer, got string"},
+			{"/name", "must be string, got integer"},
+		}},
+		{"not integer", `{"name":"w","cpu":1.5}`, []Error{{"/cpu", "must be integer, got number"}}},
+		{"bounds", `{"name":"","cpu":65}`, []Error{
+			{"/cpu", "must be <= 64"},
+			{"/name", "must be at least 1 characters"},
+			{"/name", `must match pattern "^[A-Za-z0-9-]+$"`},
+		}},
+		{"max length", `{"name":"web-0123456789-xx","cpu":1}`, []Error{{"/name", "must be at most 15 characters"}}},
+		{"enum", `{"name":"w","cpu":1,"state":"paused"}`, []Error{{"/state", `must be one of ["on","off"]`}}},
+		{"items", `{"name":"w","cpu":1,"tags":["a",2]}`, []Error{{"/tags/1", "must be string, got integer"}}},
+		{"type list mismatch", `{"name":"w","cpu":1,"gen":true}`, []Error{{"/gen", "must be integer or string, got boolean"}}},
+		{"anyOf", `{"name":"w","cpu":1,"disk":{}}`, []Error{{"/disk", "must satisfy one of: must be string, got object | /path is required"}}},
+		{"additional schema", `{"name":"w","cpu":1,"meta":{"k":1}}`, []Error{{"/meta/k", "must be string, got integer"}}},
+		{"unknown property", `{"name":"w","cpu":1,"zz":1}`, []Error{{"/zz", "unknown property"}}},
+		{"false schema, escaped pointer", `{"name":"w","cpu":1,"a/b":1}`, []Error{{"/a~1b", "is not allowed"}}},
+		{"root type", `[]`, []Error{{"", "must be object, got array"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var v any
+			if err := json.Unmarshal([]byte(c.payload), &v); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Validate(v); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Validate(%s)\n got  %v\n want %v", c.payload, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		schema  string
+		wantErr string
+	}{
+		{`true`, ""},
+		{`{"type":"string"}`, ""},
+		{`{"properties":{"x":{"pattern":"("}}}`, "/properties/x: invalid pattern"},
+		{`{"items":{"anyOf":[{"pattern":"["}]}}`, "/items/anyOf/0: invalid pattern"},
+		{`{"type":1}`, "type: expected string or array of strings"},
+		{`{"properties":[]}`, "cannot unmarshal"},
+	}
+	for _, c := range cases {
+		_, err := Parse([]byte(c.schema))
+		if c.wantErr == "" && err != nil {
+			t.Errorf("Parse(%s): %v", c.schema, err)
+		}
+		if c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("Parse(%s) = %v, want %q", c.schema, err, c.wantErr)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	got := Join([]Error{{"", "must be object, got null"}, {"/a", "is required"}})
+	if want := "(root): must be object, got null; /a: is required"; got != want {
+		t.Errorf("Join = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Durée pendant laquelle une annulation reçue avant le démarrage de la tâche reste mémorisée.
+const pendingCancelTTL = 15 * time.Minute
+
+var (
+	inflightMu     sync.Mutex
+	inflight       = map[string]context.CancelCauseFunc{}
+	pendingCancels = map[string]pendingCancel{}
+	cancelGrace    = 30 * time.Second
+)
+
+type pendingCancel struct {
+	reason string
+	at     time.Time
+}
+
+// SetCancelGrace règle le délai laissé aux scripts, après le signal d'annulation, avant le kill.
+func SetCancelGrace(sec int) {
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+	if sec >= 0 {
+		cancelGrace = time.Duration(sec) * time.Second
+	}
+}
+
+// CancelTask annule la tâche taskID si elle est en cours et renvoie true.
+// Sinon l'annulation est mémorisée: la tâche sera refusée si elle démarre plus tard
+// (encore en attente de verrou / de worker).
+func CancelTask(taskID, reason string) bool {
+	if taskID == "" {
+		return false
+	}
+	if reason == "" {
+		reason = "cancelled by controller"
+	}
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+	if cancel, ok := inflight[taskID]; ok {
+		cancel(errors.New(reason))
+		return true
+	}
+	now := time.Now()
+	for id, p := range pendingCancels {
+		if now.Sub(p.at) > pendingCancelTTL {
+			delete(pendingCancels, id)
+		}
+	}
+	pendingCancels[taskID] = pendingCancel{reason: reason, at: now}
+	return false
+}
+
+// trackTask enregistre une tâche en vol et renvoie son contexte (annulable par CancelTask)
+// ainsi que la fonction de désenregistrement. Le contexte est déjà annulé si une
+// annulation a été reçue avant le démarrage.
+func trackTask(taskID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	if taskID == "" {
+		return ctx, func() { cancel(nil) }
+	}
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+	if p, ok := pendingCancels[taskID]; ok {
+		delete(pendingCancels, taskID)
+		cancel(errors.New(p.reason))
+	}
+	inflight[taskID] = cancel
+	return ctx, func() {
+		inflightMu.Lock()
+		delete(inflight, taskID)
+		inflightMu.Unlock()
+		cancel(nil)
+	}
+}
+
+func currentCancelGrace() time.Duration {
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+	return cancelGrace
+}
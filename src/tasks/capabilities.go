@@ -0,0 +1,117 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/powershell"
+)
+
+// ErrCapabilityDisabled: la capability de l'action n'est pas configurée.
+var ErrCapabilityDisabled = errors.New("capability not enabled")
+
+var (
+	capsMu   sync.RWMutex
+	enabled  = map[string]bool{}
+	allowAll = true // aucune capability configurée: tout le catalogue est servi
+)
+
+// SetCapabilities fixe les capabilities activées (config.Capabilities). Une entrée
+// portant exactement le nom d'une action l'autorise aussi (ex: "vm.debug-master").
+// Une liste vide autorise toutes les actions connues (manifests + actions enregistrées).
+func SetCapabilities(caps []string) {
+	on := make(map[string]bool, len(caps))
+	for _, c := range caps {
+		on[c] = true
+	}
+	capsMu.Lock()
+	enabled = on
+	allowAll = len(caps) == 0
+	capsMu.Unlock()
+}
+
+// CapabilityOf renvoie la capability d'une action: celle de son manifest,
+// sinon le nom de l'action (actions natives / externes).
+func CapabilityOf(action string) string {
+	if m, ok := powershell.ActionManifest(action); ok {
+		return m.Capability
+	}
+	return action
+}
+
+// ActionAllowed indique si une capability configurée couvre l'action.
+func ActionAllowed(action string) bool {
+	capsMu.RLock()
+	defer capsMu.RUnlock()
+	return allowAll || enabled[CapabilityOf(action)] || enabled[action]
+}
+
+// Capabilities: capabilities effectivement servies (publiées dans le heartbeat),
+// dérivées des manifests et des actions enregistrées, limitées à la config.
+func Capabilities() []string {
+	seen := map[string]bool{}
+	for _, a := range knownActions() {
+		if ActionAllowed(a) {
+			seen[CapabilityOf(a)] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for c := range seen {
+		out = append(out, c)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ActionVersions: action autorisée -> version du script (manifest) ou "native" / "external".
+func ActionVersions() map[string]string {
+	out := map[string]string{}
+	for _, a := range knownActions() {
+		if !ActionAllowed(a) {
+			continue
+		}
+		switch ExecutorFor(a).(type) {
+		case FuncExecutor, WorkflowExecutor:
+			out[a] = "native"
+		case BinaryExecutor:
+			out[a] = "external"
+		default:
+			if m, ok := powershell.ActionManifest(a); ok {
+				out[a] = m.Version
+			}
+		}
+	}
+	return out
+}
+
+// knownActions: actions décrites par un manifest + actions enregistrées (natives / externes).
+func knownActions() []string {
+	seen := map[string]bool{}
+	for _, a := range powershell.CurrentCatalog().Names() {
+		seen[a] = true
+	}
+	for _, a := range RegisteredActions() {
+		seen[a] = true
+	}
+	out := make([]string, 0, len(seen))
+	for a := range seen {
+		out = append(out, a)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// capabilityDisabled construit le résultat structuré renvoyé sans lancer PowerShell.
+func capabilityDisabled(action string) (map[string]any, error) {
+	err := amqp.CodedError(amqp.CodeCapabilityDisabled, fmt.Errorf("%w for action %s", ErrCapabilityDisabled, action))
+	return map[string]any{
+		"ok":         false,
+		"error":      err.Error(),
+		"code":       amqp.CodeCapabilityDisabled,
+		"action":     action,
+		"capability": CapabilityOf(action), // capability à activer pour autoriser l'action
+	}, err
+}
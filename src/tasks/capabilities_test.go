@@ -0,0 +1,26 @@
+package tasks
+
+import "testing"
+
+func TestActionAllowed(t *testing.T) {
+	t.Cleanup(func() { SetCapabilities(nil) })
+	cases := []struct {
+		name   string
+		caps   []string
+		action string
+		want   bool
+	}{
+		{"non configuré: tout est servi", nil, "vm.create", true},
+		{"liste vide: tout est servi", []string{}, "echo", true},
+		{"capability absente", []string{"inventory"}, "vm.create", false},
+		{"nom d'action direct", []string{"vm.create"}, "vm.create", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			SetCapabilities(c.caps)
+			if got := ActionAllowed(c.action); got != c.want {
+				t.Fatalf("ActionAllowed(%q) with %v = %v, want %v", c.action, c.caps, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package tasks
+
+import "sync"
+
+// Classes d'actions: plafonds de concurrence, workers réservés et queue (lane) de consommation
+const (
+	ClassInteractive = "interactive" // actions courtes attendues par un utilisateur
+	ClassDefault     = "default"
+	ClassBulk        = "bulk" // opérations longues (copie VHDX, déplacements disque)
+)
+
+var defaultActionClasses = map[string]string{
+	"vm.power":            ClassInteractive,
+	"console.serial.open": ClassInteractive,
+	"echo":                ClassInteractive,
+	"vm.edit":             ClassDefault,
+	"vm.create":           ClassBulk,
+	"vm.delete":           ClassBulk,
+}
+
+var (
+	classesMu     sync.RWMutex
+	actionClasses = defaultActionClasses
+)
+
+// SetActionClasses surcharge la classe de certaines actions (action -> classe).
+func SetActionClasses(overrides map[string]string) {
+	m := make(map[string]string, len(defaultActionClasses)+len(overrides))
+	for k, v := range defaultActionClasses {
+		m[k] = v
+	}
+	for k, v := range overrides {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	classesMu.Lock()
+	actionClasses = m
+	classesMu.Unlock()
+}
+
+// ActionClass retourne la classe d'une action (ClassDefault si inconnue).
+func ActionClass(action string) string {
+	classesMu.RLock()
+	defer classesMu.RUnlock()
+	if c, ok := actionClasses[action]; ok {
+		return c
+	}
+	return ClassDefault
+}
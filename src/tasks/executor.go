@@ -0,0 +1,131 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/powershell"
+)
+
+// ExecRequest: ce qu'un executor reçoit pour une tâche.
+type ExecRequest struct {
+	Task       amqp.Task // tâche d'origine (taskId, tenant)
+	Action     string
+	Data       map[string]any            // payload fusionné (__ctx inclus)
+	OnProgress func(powershell.Progress) // -> results / task.<id>.progress
+	Grace      time.Duration             // délai de grâce sur annulation (task.cancel)
+	Diag       *powershell.Diagnostics   // rempli par l'executor (code de sortie, tailles, fin de STDERR)
+	Manifest   *powershell.Manifest      // entrée du catalogue prise à l'admission (nil: action sans manifest)
+}
+
+// Executor implémente une action. La sortie suit le contrat des scripts: un JSON sur
+// "stdout" ({ ok, result, error, code } ou le résultat brut). Sur échec, renvoyer la
+// sortie éventuelle avec une erreur (une *amqp.TaskError est publiée telle quelle).
+// ctx porte l'échéance de l'action et l'annulation distante.
+type Executor interface {
+	Execute(ctx context.Context, req ExecRequest) ([]byte, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Executor{
+		"echo":         FuncExecutor(echoAction), // natif: pas de pwsh pour un aller-retour
+		WorkflowAction: WorkflowExecutor{},       // étapes enchaînées, compensation en cas d'échec
+	}
+)
+
+// defaultExecutor: toute action non enregistrée est un script powershell/actions/<action>.ps1.
+var defaultExecutor Executor = PowerShellExecutor{}
+
+// Register associe une action à un executor (remplace l'éventuel précédent).
+func Register(action string, e Executor) {
+	registryMu.Lock()
+	registry[action] = e
+	registryMu.Unlock()
+}
+
+// ExecutorFor renvoie l'executor de l'action (PowerShell par défaut).
+func ExecutorFor(action string) Executor {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if e, ok := registry[action]; ok {
+		return e
+	}
+	return defaultExecutor
+}
+
+// RegisteredActions liste les actions enregistrées explicitement (hors scripts).
+func RegisteredActions() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	out := make([]string, 0, len(registry))
+	for a := range registry {
+		out = append(out, a)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// PowerShellExecutor exécute powershell/actions/<action>.ps1 (worker persistant ou pwsh -File).
+type PowerShellExecutor struct{}
+
+func (PowerShellExecutor) Execute(ctx context.Context, req ExecRequest) ([]byte, error) {
+	return powershell.RunActionScriptOpts(ctx, req.Action, req.Data, powershell.RunOpts{
+		OnProgress: req.OnProgress,
+		Grace:      req.Grace,
+		Diag:       req.Diag,
+		Env:        req.env(),
+		Manifest:   req.Manifest,
+	})
+}
+
+// FuncExecutor: action implémentée en Go. Le résultat est publié tel quel (comme le
+// STDOUT d'un script); sur erreur: { ok:false, error, code }.
+type FuncExecutor func(ctx context.Context, req ExecRequest) (any, error)
+
+func (f FuncExecutor) Execute(ctx context.Context, req ExecRequest) ([]byte, error) {
+	res, err := f(ctx, req)
+	if req.Diag != nil {
+		req.Diag.Runner = "native"
+		req.Diag.ExitCode = 0
+		if err != nil {
+			req.Diag.ExitCode = 1
+		}
+	}
+	if err == nil {
+		return json.Marshal(res)
+	}
+	out := map[string]any{"ok": false, "error": err.Error()}
+	var te *amqp.TaskError
+	if errors.As(err, &te) {
+		out["code"] = te.Code
+	}
+	b, _ := json.Marshal(out)
+	return b, err
+}
+
+// BinaryExecutor: action déléguée à un exécutable externe. Il reçoit { action, data }
+// en JSON sur STDIN, répond sur STDOUT, et peut émettre des lignes ProgressPrefix sur STDERR.
+type BinaryExecutor struct {
+	Path string
+	Args []string
+}
+
+func (b BinaryExecutor) Execute(ctx context.Context, req ExecRequest) ([]byte, error) {
+	// Intégrité: l'exécutable contre integrity.json signé (même alerte que pour les scripts)
+	if err := powershell.CheckBinary(req.Action, b.Path); err != nil {
+		return nil, err
+	}
+	stdin, _ := json.Marshal(map[string]any{"action": req.Action, "data": req.Data})
+	return powershell.RunProcess(ctx, b.Path, b.Args, stdin, powershell.RunOpts{
+		OnProgress: req.OnProgress,
+		Grace:      req.Grace,
+		Diag:       req.Diag,
+		Env:        req.env(),
+	})
+}
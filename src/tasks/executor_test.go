@@ -0,0 +1,113 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/powershell"
+)
+
+func TestExecutorFor(t *testing.T) {
+	custom := FuncExecutor(func(context.Context, ExecRequest) (any, error) { return "custom", nil })
+	Register("test.custom", custom)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, "test.custom")
+		registryMu.Unlock()
+	})
+
+	cases := []struct {
+		action string
+		want   string
+	}{
+		{"echo", "tasks.FuncExecutor"},
+		{WorkflowAction, "tasks.WorkflowExecutor"},
+		{"test.custom", "tasks.FuncExecutor"},
+		{"vm.power", "tasks.PowerShellExecutor"},
+		{"", "tasks.PowerShellExecutor"},
+	}
+	for _, c := range cases {
+		if got := fmt.Sprintf("%T", ExecutorFor(c.action)); got != c.want {
+			t.Errorf("ExecutorFor(%q) = %s, want %s", c.action, got, c.want)
+		}
+	}
+}
+
+func TestFuncExecutor(t *testing.T) {
+	cases := []struct {
+		name     string
+		fn       FuncExecutor
+		wantOut  string
+		wantCode amqp.ErrorCode
+		wantExit int
+	}{
+		{
+			name:    "ok",
+			fn:      func(context.Context, ExecRequest) (any, error) { return map[string]any{"n": 1}, nil },
+			wantOut: `{"n":1}`,
+		},
+		{
+			name:     "plain error",
+			fn:       func(context.Context, ExecRequest) (any, error) { return nil, errors.New("boom") },
+			wantOut:  `{"error":"boom","ok":false}`,
+			wantExit: 1,
+		},
+		{
+			name: "typed error",
+			fn: func(context.Context, ExecRequest) (any, error) {
+				return nil, amqp.CodedError(amqp.CodeNotFound, errors.New("no vm"))
+			},
+			wantOut:  `{"code":"NOT_FOUND","error":"no vm","ok":false}`,
+			wantCode: amqp.CodeNotFound,
+			wantExit: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			diag := powershell.Diagnostics{ExitCode: -1}
+			out, err := c.fn.Execute(context.Background(), ExecRequest{Action: "x", Diag: &diag})
+			if string(out) != c.wantOut {
+				t.Errorf("out = %s, want %s", out, c.wantOut)
+			}
+			if (err != nil) != (c.wantExit != 0) {
+				t.Errorf("err = %v", err)
+			}
+			if c.wantCode != "" {
+				if code, _ := amqp.Classify(err); code != c.wantCode {
+					t.Errorf("code = %s, want %s", code, c.wantCode)
+				}
+			}
+			if diag.Runner != "native" || diag.ExitCode != c.wantExit {
+				t.Errorf("diag = %s/%d, want native/%d", diag.Runner, diag.ExitCode, c.wantExit)
+			}
+		})
+	}
+}
+
+func TestEchoAction(t *testing.T) {
+	data := map[string]any{"msg": "hello", "n": float64(2), "__ctx": map[string]any{"taskId": "t1"}}
+	raw, err := ExecutorFor("echo").Execute(context.Background(), ExecRequest{Action: "echo", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Action string         `json:"action"`
+		Echo   map[string]any `json:"echo"`
+		Meta   map[string]any `json:"meta"`
+	}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", raw, err)
+	}
+	if got.Action != "echo" || got.Echo["msg"] != "hello" || got.Echo["n"] != float64(2) {
+		t.Errorf("echo = %s", raw)
+	}
+	for _, k := range []string{"when", "host", "go"} {
+		if _, ok := got.Meta[k]; !ok {
+			t.Errorf("meta.%s missing in %s", k, raw)
+		}
+	}
+}
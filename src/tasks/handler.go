@@ -1,39 +1,191 @@
 package tasks
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"openhvx-agent/amqp"
 	"openhvx-agent/powershell"
 )
 
+// HandleTask exécute une tâche; le résultat est publié avec un bloc "diagnostics"
+// (runner, code de sortie, durée, tailles et fin de STDERR) même en cas de refus.
 func HandleTask(t amqp.Task) (any, error) {
+	diag := powershell.Diagnostics{ExitCode: -1}
+	res, err := handleTask(t, &diag)
+	return amqp.Diagnosed{Result: res, Diagnostics: diag, ExitCode: diag.ExitCode}, err
+}
+
+func handleTask(t amqp.Task, diag *powershell.Diagnostics) (any, error) {
 	log.Printf("[TASK] action=%s taskId=%s tenant=%s", t.Action, t.TaskID, t.TenantID)
+	m, out, err := admit(t)
+	if err != nil {
+		return out, err
+	}
+
+	// Exécution annulable à distance (task.cancel); progression -> results / task.<id>.progress
+	base, untrack := trackTask(t.TaskID)
+	defer untrack()
+	progress := newProgressRelay(t)
+	res, err := runAction(base, t, m, diag, progress.push)
+	progress.close()
+	return res, err
+}
+
+// admit: contrôles préalables, sans démarrer PowerShell (tâche ou étape de workflow).
+// Renvoie l'entrée du catalogue validée (nil sans manifest): la tâche s'exécute sur cette
+// version, même si le catalogue est rechargé avant son démarrage.
+func admit(t amqp.Task) (*powershell.Manifest, map[string]any, error) {
+	m, _ := powershell.ActionManifest(t.Action)
+
+	// 0) Capability: refus immédiat
+	if !ActionAllowed(t.Action) {
+		log.Printf("[TASK] rejected action=%s taskId=%s: capability not enabled", t.Action, t.TaskID)
+		out, err := capabilityDisabled(t.Action)
+		return nil, out, err
+	}
+
+	// 0ter) Dry-run: seulement pour les actions qui le déclarent (ou sans effet de bord)
+	if t.DryRun && !DryRunSupported(t.Action) {
+		log.Printf("[TASK] rejected action=%s taskId=%s: dry-run not supported", t.Action, t.TaskID)
+		err := amqp.CodedError(amqp.CodeDryRunUnsupported, fmt.Errorf("action %s does not support dryRun", t.Action))
+		return nil, map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeDryRunUnsupported, "action": t.Action}, err
+	}
+
+	// 0quater) Intégrité de la version admise (script, manifest, schéma tels que chargés)
+	if m != nil {
+		if err := m.Verify(); err != nil {
+			log.Printf("[TASK] blocked action=%s taskId=%s: %v", t.Action, t.TaskID, err)
+			return nil, map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeIntegrity},
+				amqp.CodedError(amqp.CodeIntegrity, err)
+		}
+	}
+
+	// 0bis) Schéma d'entrée: toutes les erreurs d'un coup
+	if out, err := validateInput(t.Action, m, t.Data); err != nil {
+		log.Printf("[TASK] rejected action=%s taskId=%s: %v", t.Action, t.TaskID, err)
+		return nil, out, err
+	}
+	return m, nil, nil
+}
+
+// cancelledBy: base a été annulé par task.cancel (l'échéance d'un workflow n'en est pas une).
+func cancelledBy(base context.Context) bool {
+	return base.Err() != nil && !errors.Is(context.Cause(base), context.DeadlineExceeded)
+}
+
+// cancelledResult: résultat d'une tâche annulée, avec la sortie partielle éventuelle du script.
+func cancelledResult(base context.Context, t amqp.Task, raw []byte) (any, error) {
+	reason := context.Cause(base)
+	log.Printf("[TASK] cancelled action=%s taskId=%s reason=%v", t.Action, t.TaskID, reason)
+	out := map[string]any{"ok": false, "error": "cancelled: " + reason.Error()}
+	var obj any
+	if len(raw) > 0 && json.Unmarshal(raw, &obj) == nil {
+		out["partial"] = obj // ex: rapport de rollback de vm.create
+	}
+	return out, fmt.Errorf("%w: %v", amqp.ErrTaskCancelled, reason)
+}
+
+// runAction exécute l'action de t via son executor, bornée par son délai, et type le
+// résultat. base porte l'annulation distante (tâche, ou workflow pour une étape);
+// m est l'entrée du catalogue renvoyée par admit.
+func runAction(base context.Context, t amqp.Task, m *powershell.Manifest, diag *powershell.Diagnostics, onProgress func(powershell.Progress)) (any, error) {
+	// 0) Annulée avant le démarrage (attente du verrou VM ou d'un worker): rien n'est lancé
+	if cancelledBy(base) {
+		return cancelledResult(base, t, nil)
+	}
 
 	// 1) Merge des params: on ajoute __ctx sans écraser les clés métier
 	merged := make(map[string]any, len(t.Data)+1)
 	for k, v := range t.Data {
 		merged[k] = v
 	}
-	merged["__ctx"] = ctxMap(t.TenantID) // ⬅️ CONTEXTE STANDARD
+	merged["__ctx"] = ctxMap(t) // ⬅️ CONTEXTE STANDARD
+
+	// 2) Exécuter l'action via son executor (script PowerShell par défaut)
+	timeout := taskTimeout(t, m)
+	ctx, cancel := context.WithTimeout(base, timeout)
+	defer cancel()
+	start := time.Now()
+	raw, err := ExecutorFor(t.Action).Execute(ctx, ExecRequest{
+		Task:       t,
+		Action:     t.Action,
+		Data:       merged,
+		OnProgress: onProgress,
+		Grace:      currentCancelGrace(),
+		Diag:       diag,
+		Manifest:   m,
+	})
+	diag.DurationMs = time.Since(start).Milliseconds() // durée murale, attente d'un worker comprise
 
-	// 2) Exécuter le script
-	raw, err := powershell.RunActionScript(t.Action, merged)
+	// 2a) Annulée par le controller (task.cancel); l'échéance d'un workflow n'est pas une annulation
+	if err != nil && cancelledBy(base) {
+		return cancelledResult(base, t, raw)
+	}
+
+	// 2bis) Action inconnue: inutile de rejouer
+	if errors.Is(err, powershell.ErrScriptNotFound) || errors.Is(err, powershell.ErrNoManifest) {
+		return map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeUnknownAction},
+			amqp.CodedError(amqp.CodeUnknownAction, err)
+	}
+	if errors.Is(err, powershell.ErrIntegrity) {
+		log.Printf("[TASK] blocked action=%s taskId=%s: %v", t.Action, t.TaskID, err)
+		return map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeIntegrity},
+			amqp.CodedError(amqp.CodeIntegrity, err)
+	}
+	if errors.Is(err, powershell.ErrPwshNotFound) {
+		return map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeBackendUnavailable},
+			amqp.CodedError(amqp.CodeBackendUnavailable, err)
+	}
+	// Sortie tronquée: JSON inexploitable, un nouvel essai produirait la même
+	if errors.Is(err, powershell.ErrOutputTooLarge) {
+		return map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeOutputTooLarge},
+			amqp.CodedError(amqp.CodeOutputTooLarge, err)
+	}
+
+	// 2ter) Délai dépassé: erreur distincte pour que le controller sépare "lent" de "échoué"
+	if errors.Is(err, powershell.ErrTimeout) || (err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+		log.Printf("[TASK] timeout action=%s taskId=%s after=%s", t.Action, t.TaskID, timeout)
+		return map[string]any{"ok": false, "raw": string(raw)},
+			fmt.Errorf("%w: %s exceeded %s", amqp.ErrTaskTimeout, t.Action, timeout)
+	}
 
 	// 3) Toujours essayer d’unmarshal
 	var obj any
 	if uErr := json.Unmarshal(raw, &obj); uErr == nil {
 		if err != nil {
-			return obj, fmt.Errorf("action script failed")
+			var te *amqp.TaskError
+			if errors.As(err, &te) {
+				return obj, err // erreur déjà typée par l'executor
+			}
+			return obj, scriptError(obj)
 		}
 		return obj, nil
 	}
 
 	// 4) Sinon renvoyer stdout brut + statut ok/ko
 	if err != nil {
-		return map[string]any{"ok": false, "raw": string(raw)}, fmt.Errorf("action script failed")
+		return map[string]any{"ok": false, "raw": string(raw), "code": amqp.CodeScriptCrash},
+			amqp.CodedError(amqp.CodeScriptCrash, fmt.Errorf("action script failed: %w", err))
 	}
 	return map[string]any{"ok": true, "raw": string(raw)}, nil
 }
+
+// scriptError type l'échec d'un script d'après sa sortie JSON: champ "code"
+// (taxonomie amqp.ErrorCode) et "retryable" optionnel; ACTION_FAILED à défaut.
+func scriptError(obj any) error {
+	m, _ := obj.(map[string]any)
+	code := amqp.CodeActionFailed
+	if c, ok := m["code"].(string); ok && c != "" {
+		code = amqp.ErrorCode(c)
+	}
+	te := &amqp.TaskError{Code: code, Retryable: code.Retryable(), Err: errors.New("action script failed")}
+	if r, ok := m["retryable"].(bool); ok {
+		te.Retryable = r
+	}
+	return te
+}
@@ -0,0 +1,30 @@
+package tasks
+
+import (
+	"testing"
+
+	"openhvx-agent/amqp"
+)
+
+func TestScriptError(t *testing.T) {
+	cases := []struct {
+		name      string
+		out       any
+		wantCode  amqp.ErrorCode
+		wantRetry bool
+	}{
+		{"sans code: pas de rejeu", map[string]any{"ok": false, "error": "boom"}, amqp.CodeActionFailed, false},
+		{"sans code, rejeu demandé", map[string]any{"ok": false, "retryable": true}, amqp.CodeActionFailed, true},
+		{"code typé", map[string]any{"ok": false, "code": "BACKEND_UNAVAILABLE"}, amqp.CodeBackendUnavailable, true},
+		{"code typé, rejeu refusé", map[string]any{"ok": false, "code": "BACKEND_UNAVAILABLE", "retryable": false}, amqp.CodeBackendUnavailable, false},
+		{"sortie non objet", []any{1}, amqp.CodeActionFailed, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, retry := amqp.Classify(scriptError(c.out))
+			if code != c.wantCode || retry != c.wantRetry {
+				t.Errorf("scriptError = %s/%v, want %s/%v", code, retry, c.wantCode, c.wantRetry)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package tasks
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Actions natives (Go), enregistrées dans registry.
+
+// echoAction renvoie le payload reçu (même forme que l'ancien echo.ps1).
+func echoAction(_ context.Context, req ExecRequest) (any, error) {
+	host, _ := os.Hostname()
+	return map[string]any{
+		"action": req.Action,
+		"echo":   req.Data,
+		"meta": map[string]any{
+			"when": time.Now().UTC().Format(time.RFC3339Nano),
+			"host": host,
+			"user": currentUser(),
+			"go":   runtime.Version(),
+		},
+	}, nil
+}
+
+func currentUser() string {
+	if u := os.Getenv("USERNAME"); u != "" {
+		return u
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,49 @@
+package tasks
+
+import (
+	"log"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/powershell"
+)
+
+// PublishProgress publie un point de progression (remplacé par le runner local "action": pas d'AMQP).
+var PublishProgress = func(t amqp.Task, seq int, p powershell.Progress) error {
+	return amqp.PublishTaskProgress(rt.AgentID, t, seq, p.Percent, p.Step, p.Message)
+}
+
+// progressRelay publie la progression d'une tâche sans bloquer le script:
+// les points sont mis en file et publiés par une goroutine dédiée (file pleine = point ignoré).
+type progressRelay struct {
+	t    amqp.Task
+	ch   chan powershell.Progress
+	done chan struct{}
+}
+
+func newProgressRelay(t amqp.Task) *progressRelay {
+	r := &progressRelay{t: t, ch: make(chan powershell.Progress, 32), done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		seq := 0
+		for p := range r.ch {
+			seq++
+			if err := PublishProgress(r.t, seq, p); err != nil {
+				log.Printf("[TASK] progress publish error taskId=%s: %v", r.t.TaskID, err)
+			}
+		}
+	}()
+	return r
+}
+
+func (r *progressRelay) push(p powershell.Progress) {
+	select {
+	case r.ch <- p:
+	default:
+	}
+}
+
+// close attend la publication des points en file.
+func (r *progressRelay) close() {
+	close(r.ch)
+	<-r.done
+}
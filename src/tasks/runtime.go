@@ -1,6 +1,12 @@
 package tasks
 
-import "openhvx-agent/datadirs"
+import (
+	"sort"
+	"strings"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/datadirs"
+)
 
 type runtimeCtx struct {
 	AgentID    string
@@ -25,6 +31,7 @@ func SetRuntimeContext(agentID, basePath string, d datadirs.DataDirs) {
 			"isos":        d.ISOs,   // legacy / compat
 			"checkpoints": d.Checkpoints,
 			"logs":        d.Logs,
+			"state":       d.State,
 			"trash":       d.Trash,
 		},
 		Datastores: []map[string]any{
@@ -40,14 +47,42 @@ func SetRuntimeContext(agentID, basePath string, d datadirs.DataDirs) {
 }
 
 // ctxMap expose un contexte simple pour inclure dans les payloads retournés par l'agent
-func ctxMap(tenantID string) map[string]any {
-	return map[string]any{
+func ctxMap(t amqp.Task) map[string]any {
+	m := map[string]any{
 		"agentId":    rt.AgentID,
-		"tenantId":   tenantID,
+		"tenantId":   t.TenantID,
 		"basePath":   rt.BasePath,
 		"paths":      rt.Paths,
 		"datastores": rt.Datastores,
 	}
+	if t.DryRun {
+		m["dryRun"] = true // le script décrit ce qu'il ferait, sans rien modifier
+	}
+	return m
+}
+
+// env: variables OPENHVX_* de la tâche pour les scripts et exécutables externes
+// (l'environnement de l'agent n'est pas hérité, voir powershell.SetScriptEnv).
+func (r ExecRequest) env() []string {
+	env := []string{
+		"OPENHVX_AGENT_ID=" + rt.AgentID,
+		"OPENHVX_TASK_ID=" + r.Task.TaskID,
+		"OPENHVX_TENANT_ID=" + r.Task.TenantID,
+		"OPENHVX_ACTION=" + r.Action,
+		"OPENHVX_BASE_PATH=" + rt.BasePath,
+	}
+	keys := make([]string, 0, len(rt.Paths))
+	for k := range rt.Paths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, "OPENHVX_PATH_"+strings.ToUpper(k)+"="+rt.Paths[k]) // OPENHVX_PATH_VHD, ...
+	}
+	if r.Task.DryRun {
+		env = append(env, "OPENHVX_DRY_RUN=1")
+	}
+	return env
 }
 
 // GetRuntimeContext retourne une copie du contexte courant (utile en debug/tests)
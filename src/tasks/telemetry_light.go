@@ -27,7 +27,10 @@ func KickLightRefresh(ctx context.Context, lc LightCtx) {
 			},
 		}
 
-		raw, err := powershell.RunActionScript("inventory.refresh.light", payload)
+		runCtx, cancel := context.WithTimeout(ctx, ActionTimeout("inventory.refresh.light"))
+		defer cancel()
+
+		raw, err := powershell.RunActionScriptContext(runCtx, "inventory.refresh.light", payload)
 		if err != nil {
 			log.Println("inventory light error:", err)
 			return
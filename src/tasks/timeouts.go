@@ -0,0 +1,65 @@
+package tasks
+
+import (
+	"sync"
+	"time"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/powershell"
+)
+
+var (
+	timeoutsMu     sync.RWMutex
+	defaultTimeout = 10 * time.Minute
+	actionTimeouts = map[string]time.Duration{}
+)
+
+// SetActionTimeouts configure les délais max d'exécution (en secondes).
+// perActionSec prime sur le timeoutSec du manifest; defaultSec s'applique sinon.
+func SetActionTimeouts(defaultSec int, perActionSec map[string]int) {
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+	if defaultSec > 0 {
+		defaultTimeout = time.Duration(defaultSec) * time.Second
+	}
+	actionTimeouts = make(map[string]time.Duration, len(perActionSec))
+	for action, sec := range perActionSec {
+		if sec > 0 {
+			actionTimeouts[action] = time.Duration(sec) * time.Second
+		}
+	}
+}
+
+// ActionTimeout retourne le délai applicable à une action: config > manifest > défaut.
+func ActionTimeout(action string) time.Duration {
+	m, _ := powershell.ActionManifest(action)
+	return actionTimeout(action, m)
+}
+
+// actionTimeout: comme ActionTimeout, avec le manifest pris à l'admission (nil si aucun).
+func actionTimeout(action string, m *powershell.Manifest) time.Duration {
+	timeoutsMu.RLock()
+	defer timeoutsMu.RUnlock()
+	if d, ok := actionTimeouts[action]; ok {
+		return d
+	}
+	if m != nil && m.TimeoutSec > 0 {
+		return time.Duration(m.TimeoutSec) * time.Second
+	}
+	return defaultTimeout
+}
+
+// taskTimeout: délai d'une tâche. Un workflow sans surcharge config dispose de la
+// somme des délais de ses étapes et de leurs compensations. m: manifest pris à l'admission.
+func taskTimeout(t amqp.Task, m *powershell.Manifest) time.Duration {
+	timeoutsMu.RLock()
+	d, ok := actionTimeouts[t.Action]
+	timeoutsMu.RUnlock()
+	if ok {
+		return d
+	}
+	if t.Action == WorkflowAction {
+		return workflowTimeout(t.Data)
+	}
+	return actionTimeout(t.Action, m)
+}
@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/powershell"
+	"openhvx-agent/schema"
+)
+
+// ErrInvalidInput: payload non conforme au schéma de l'action.
+var ErrInvalidInput = errors.New("invalid input")
+
+// validateInput vérifie t.Data contre le schéma d'entrée de l'action (si présent): celui
+// du manifest m pris à l'admission, sinon <action>.schema.json (action native).
+// Renvoie (nil, nil) si conforme ou sans schéma; sinon le résultat structuré.
+func validateInput(action string, m *powershell.Manifest, data map[string]any) (map[string]any, error) {
+	var raw []byte
+	var err error
+	if m != nil {
+		raw, err = m.Schema()
+	} else {
+		raw, err = powershell.LoadActionSchema(action)
+	}
+	if errors.Is(err, powershell.ErrNoSchema) {
+		return nil, nil
+	}
+	var s *schema.Schema
+	if err == nil {
+		s, err = schema.Parse(raw)
+	}
+	if err != nil {
+		// Schéma illisible = problème de déploiement de l'agent, pas du payload
+		log.Printf("[TASK] schema error action=%s: %v", action, err)
+		err = fmt.Errorf("schema for %s: %w", action, err)
+		return map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeInternal},
+			amqp.Permanent(amqp.CodedError(amqp.CodeInternal, err))
+	}
+
+	var v any = map[string]any{}
+	if data != nil {
+		v = data
+	}
+	errs := s.Validate(v)
+	if len(errs) == 0 {
+		return nil, nil
+	}
+	err = amqp.CodedError(amqp.CodeInvalidInput, fmt.Errorf("%w: %s", ErrInvalidInput, schema.Join(errs)))
+	return map[string]any{
+		"ok":               false,
+		"error":            err.Error(),
+		"code":             amqp.CodeInvalidInput,
+		"action":           action,
+		"validationErrors": errs,
+	}, err
+}
+
+// ActionSchemas: schémas d'entrée des actions autorisées (publiés au controller).
+func ActionSchemas() (map[string]json.RawMessage, error) {
+	all, err := powershell.ActionSchemas()
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range RegisteredActions() {
+		if raw, err := powershell.LoadActionSchema(action); err == nil && json.Valid(raw) {
+			all[action] = raw
+		}
+	}
+	out := make(map[string]json.RawMessage, len(all))
+	for action, s := range all {
+		if ActionAllowed(action) {
+			out[action] = s
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,234 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/powershell"
+)
+
+// Actions natives en lecture seule (les scripts le déclarent via "mutating" dans leur manifest).
+var readOnlyActions = map[string]bool{
+	"echo": true,
+}
+
+// DryRunSupported: l'action accepte dryRun (manifest supportsDryRun, ou action sans effet de bord).
+func DryRunSupported(action string) bool {
+	if m, ok := powershell.ActionManifest(action); ok {
+		return m.DryRun || !m.Mutating
+	}
+	return readOnlyActions[action]
+}
+
+// readOnly: jamais sérialisée par VM.
+func readOnly(action string) bool {
+	if m, ok := powershell.ActionManifest(action); ok {
+		return !m.Mutating
+	}
+	return readOnlyActions[action]
+}
+
+// keyedLocker: un verrou FIFO par clé. Le premier de la file détient le verrou,
+// les suivants attendent dans l'ordre de réservation.
+type keyedLocker struct {
+	mu     sync.Mutex
+	queues map[string][]*lockTicket
+}
+
+var vmLocks = &keyedLocker{queues: map[string][]*lockTicket{}}
+
+type lockTicket struct {
+	l        *keyedLocker
+	key      string
+	ready    chan struct{}
+	once     sync.Once
+	queuedAt time.Time
+}
+
+// reserve prend une place dans la file de la clé sans bloquer.
+func (l *keyedLocker) reserve(key string) *lockTicket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reserveLocked(key, time.Now())
+}
+
+// reserveAll prend une place dans la file de chaque clé, atomiquement: deux réservations
+// multiples se retrouvent dans le même ordre sur toutes leurs clés communes (pas de cycle).
+func (l *keyedLocker) reserveAll(keys []string) *multiTicket {
+	now := time.Now()
+	m := &multiTicket{queuedAt: now}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range keys {
+		m.tickets = append(m.tickets, l.reserveLocked(k, now))
+	}
+	return m
+}
+
+func (l *keyedLocker) reserveLocked(key string, now time.Time) *lockTicket {
+	tk := &lockTicket{l: l, key: key, ready: make(chan struct{}), queuedAt: now}
+	q := l.queues[key]
+	l.queues[key] = append(q, tk)
+	if len(q) == 0 {
+		close(tk.ready)
+	}
+	return tk
+}
+
+func (l *keyedLocker) release(tk *lockTicket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	q := l.queues[tk.key]
+	for i, other := range q {
+		if other != tk {
+			continue
+		}
+		q = append(q[:i:i], q[i+1:]...)
+		if len(q) == 0 {
+			delete(l.queues, tk.key)
+			return
+		}
+		l.queues[tk.key] = q
+		if i == 0 {
+			close(q[0].ready) // passe la main au suivant
+		}
+		return
+	}
+}
+
+// Wait bloque jusqu'à ce que le ticket soit en tête de file et renvoie le temps d'attente.
+func (tk *lockTicket) Wait() time.Duration {
+	<-tk.ready
+	return time.Since(tk.queuedAt)
+}
+
+// WaitContext attend le verrou comme Wait, mais abandonne (et quitte la file) si ctx se termine.
+func (tk *lockTicket) WaitContext(ctx context.Context) error {
+	select {
+	case <-tk.ready:
+		return nil
+	case <-ctx.Done():
+		tk.Release()
+		return ctx.Err()
+	}
+}
+
+// Release libère le verrou (ou retire le ticket de la file s'il n'a pas encore été acquis).
+func (tk *lockTicket) Release() {
+	tk.once.Do(func() { tk.l.release(tk) })
+}
+
+// multiTicket: verrous de plusieurs VM réservés ensemble (workflow).
+type multiTicket struct {
+	tickets  []*lockTicket
+	queuedAt time.Time
+}
+
+func (m *multiTicket) Wait() time.Duration {
+	for _, tk := range m.tickets {
+		<-tk.ready
+	}
+	return time.Since(m.queuedAt)
+}
+
+func (m *multiTicket) Release() {
+	for _, tk := range m.tickets {
+		tk.Release()
+	}
+}
+
+// ReserveTaskLock réserve, à l'arrivée de la tâche, sa place dans la file de la VM ciblée.
+// Un workflow réserve d'un coup les VM de toutes ses étapes (voir workflowLockKeys).
+// Renvoie nil pour les actions en lecture seule, les dry-runs ou sans VM identifiable.
+func ReserveTaskLock(t amqp.Task) amqp.TaskLock {
+	if t.DryRun {
+		return nil
+	}
+	if t.Action == WorkflowAction {
+		if keys := workflowLockKeys(t.Data); len(keys) > 0 {
+			return vmLocks.reserveAll(keys)
+		}
+		return nil
+	}
+	if readOnly(t.Action) {
+		return nil
+	}
+	key := vmLockKey(t.Data)
+	if key == "" {
+		return nil
+	}
+	return vmLocks.reserve(key)
+}
+
+// workflowLockKeys: clés VM (triées, uniques) des étapes et compensations mutantes d'un
+// workflow, connues dès l'arrivée. Une clé qui dépend de la sortie d'une étape ("${...}")
+// est verrouillée au moment de l'étape (voir runStep).
+func workflowLockKeys(data map[string]any) []string {
+	steps, errs := parseWorkflow(data)
+	if len(errs) > 0 {
+		return nil // refusé à l'exécution
+	}
+	seen := map[string]bool{}
+	add := func(action string, d map[string]any) {
+		if readOnly(action) {
+			return
+		}
+		if k := vmLockKey(d); k != "" && !strings.Contains(k, "${") {
+			seen[k] = true
+		}
+	}
+	for _, st := range steps {
+		add(st.Action, st.Data)
+		if st.Compensate != nil {
+			add(st.Compensate.Action, st.Compensate.Data)
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// vmLockKey dérive la clé de VM: data.guid | data.id | data.target.refId | data.target | data.name.
+func vmLockKey(data map[string]any) string {
+	if data == nil {
+		return ""
+	}
+	ref := str(data["guid"])
+	if ref == "" {
+		ref = str(data["id"])
+	}
+	if ref == "" {
+		switch target := data["target"].(type) {
+		case map[string]any:
+			ref = str(target["refId"])
+		case string:
+			ref = str(target) // forme historique: "target": "<guid|nom>"
+		}
+	}
+	if ref == "" {
+		ref = str(data["name"])
+	}
+	if ref == "" {
+		return ""
+	}
+	return "vm:" + strings.ToLower(ref)
+}
+
+func str(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(x)
+	default:
+		return strings.TrimSpace(fmt.Sprint(x))
+	}
+}
@@ -0,0 +1,191 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"openhvx-agent/amqp"
+)
+
+func newLocker() *keyedLocker { return &keyedLocker{queues: map[string][]*lockTicket{}} }
+
+func acquired(tk *lockTicket) bool {
+	select {
+	case <-tk.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestKeyedLockerFIFO(t *testing.T) {
+	l := newLocker()
+	a, b, c := l.reserve("vm:1"), l.reserve("vm:1"), l.reserve("vm:1")
+	other := l.reserve("vm:2")
+
+	steps := []struct {
+		release *lockTicket
+		want    [3]bool // a, b, c acquis
+	}{
+		{nil, [3]bool{true, false, false}},
+		{a, [3]bool{true, true, false}},
+		{b, [3]bool{true, true, true}},
+	}
+	for i, st := range steps {
+		if st.release != nil {
+			st.release.Release()
+		}
+		got := [3]bool{acquired(a), acquired(b), acquired(c)}
+		if got != st.want {
+			t.Errorf("step %d: acquired = %v, want %v", i, got, st.want)
+		}
+	}
+	if !acquired(other) {
+		t.Error("vm:2 must not wait for vm:1")
+	}
+	c.Release()
+	c.Release() // idempotent
+	other.Release()
+	if len(l.queues) != 0 {
+		t.Errorf("queues not empty: %v", l.queues)
+	}
+}
+
+func TestKeyedLockerReleaseQueued(t *testing.T) {
+	l := newLocker()
+	a, b, c := l.reserve("vm:1"), l.reserve("vm:1"), l.reserve("vm:1")
+	b.Release() // quitte la file sans avoir détenu le verrou
+	if acquired(c) {
+		t.Fatal("c acquired while a holds the lock")
+	}
+	a.Release()
+	if !acquired(c) {
+		t.Fatal("c must follow a once b left the queue")
+	}
+}
+
+func TestKeyedLockerReserveAll(t *testing.T) {
+	l := newLocker()
+	first := l.reserveAll([]string{"vm:a", "vm:b"})
+	second := l.reserveAll([]string{"vm:a", "vm:b"})
+	single := l.reserve("vm:b")
+
+	done := make(chan struct{})
+	go func() {
+		first.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("first reservation should hold both locks")
+	}
+	for _, tk := range second.tickets {
+		if acquired(tk) {
+			t.Fatalf("%s acquired before first released", tk.key)
+		}
+	}
+	first.Release()
+	if !acquired(second.tickets[0]) || !acquired(second.tickets[1]) {
+		t.Fatal("second reservation should follow first on every key")
+	}
+	if acquired(single) {
+		t.Fatal("single reservation must wait for second")
+	}
+	second.Release()
+	if !acquired(single) {
+		t.Fatal("single reservation should follow second")
+	}
+}
+
+func TestLockTicketWaitContext(t *testing.T) {
+	l := newLocker()
+	a := l.reserve("vm:1")
+	b := l.reserve("vm:1")
+	c := l.reserve("vm:1")
+
+	if err := a.WaitContext(context.Background()); err != nil {
+		t.Fatalf("head of queue: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.WaitContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	a.Release()
+	if !acquired(c) {
+		t.Fatal("a ticket abandoned on ctx must leave the queue")
+	}
+}
+
+func TestWorkflowLockKeys(t *testing.T) {
+	steps := []any{
+		map[string]any{"id": "s1", "action": "vm.power", "data": map[string]any{"name": "Web01"},
+			"compensate": map[string]any{"action": "vm.delete", "data": map[string]any{"guid": "G-2"}}},
+		map[string]any{"id": "s2", "action": "echo", "data": map[string]any{"name": "readonly"}},
+		map[string]any{"id": "s3", "action": "vm.edit", "data": map[string]any{"id": "${s1.result.guid}"}},
+		map[string]any{"id": "s4", "action": "vm.edit", "data": map[string]any{"name": "web01"}},
+	}
+	got := workflowLockKeys(map[string]any{"steps": steps})
+	want := []string{"vm:g-2", "vm:web01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+	if keys := workflowLockKeys(map[string]any{"steps": []any{}}); keys != nil {
+		t.Errorf("invalid workflow: keys = %v, want nil", keys)
+	}
+}
+
+func TestVMLockKey(t *testing.T) {
+	cases := []struct {
+		data map[string]any
+		want string
+	}{
+		{nil, ""},
+		{map[string]any{}, ""},
+		{map[string]any{"guid": "ABC", "id": "x", "name": "n"}, "vm:abc"},
+		{map[string]any{"id": 42, "name": "n"}, "vm:42"},
+		{map[string]any{"target": map[string]any{"refId": " R1 "}, "name": "n"}, "vm:r1"},
+		{map[string]any{"target": "Web01", "name": "n"}, "vm:web01"},
+		{map[string]any{"target": map[string]any{"kind": "vm"}, "name": "n"}, "vm:n"},
+		{map[string]any{"name": "Web01"}, "vm:web01"},
+		{map[string]any{"guid": "  "}, ""},
+	}
+	for _, c := range cases {
+		if got := vmLockKey(c.data); got != c.want {
+			t.Errorf("vmLockKey(%v) = %q, want %q", c.data, got, c.want)
+		}
+	}
+}
+
+func TestReserveTaskLock(t *testing.T) {
+	cases := []struct {
+		name string
+		task amqp.Task
+		lock bool
+	}{
+		{"mutating", amqp.Task{Action: "vm.power", Data: map[string]any{"name": "t-lock-1"}}, true},
+		{"dry-run", amqp.Task{Action: "vm.power", DryRun: true, Data: map[string]any{"name": "t-lock-2"}}, false},
+		{"read-only", amqp.Task{Action: "echo", Data: map[string]any{"name": "t-lock-3"}}, false},
+		{"no vm", amqp.Task{Action: "vm.power", Data: map[string]any{}}, false},
+		{"string target", amqp.Task{Action: "vm.power", Data: map[string]any{"state": "off", "target": "t-lock-5"}}, true},
+		{"workflow", amqp.Task{Action: WorkflowAction, Data: map[string]any{"steps": []any{
+			map[string]any{"id": "a", "action": "vm.power", "data": map[string]any{"name": "t-lock-4"}},
+		}}}, true},
+		{"workflow read-only", amqp.Task{Action: WorkflowAction, Data: map[string]any{"steps": []any{
+			map[string]any{"id": "a", "action": "echo"},
+		}}}, false},
+	}
+	for _, c := range cases {
+		lk := ReserveTaskLock(c.task)
+		if (lk != nil) != c.lock {
+			t.Errorf("%s: lock = %v, want %v", c.name, lk != nil, c.lock)
+		}
+		if lk != nil {
+			lk.Release()
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"openhvx-agent/amqp"
+	"openhvx-agent/powershell"
+)
+
+// WorkflowAction: tâche composite, data.steps exécutées dans l'ordre.
+const WorkflowAction = "workflow"
+
+// workflowStep: une étape; data peut référencer la sortie d'une étape précédente
+// par "${<id>.<chemin>}" (ex: "${create.result.guid}"). compensate est exécutée,
+// en ordre inverse, si une étape suivante échoue.
+type workflowStep struct {
+	ID         string         `json:"id"`
+	Action     string         `json:"action"`
+	Data       map[string]any `json:"data,omitempty"`
+	Compensate *workflowCall  `json:"compensate,omitempty"`
+}
+
+type workflowCall struct {
+	Action string         `json:"action"`
+	Data   map[string]any `json:"data,omitempty"`
+}
+
+// stepRecord: compte rendu d'une étape (ou d'une compensation) dans le résultat agrégé.
+type stepRecord struct {
+	ID          string                  `json:"id"`
+	Action      string                  `json:"action"`
+	Status      string                  `json:"status"` // succeeded | failed | timeout | cancelled | skipped
+	Result      any                     `json:"result,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+	Code        amqp.ErrorCode          `json:"code,omitempty"`
+	DurationMs  int64                   `json:"durationMs,omitempty"`
+	Diagnostics *powershell.Diagnostics `json:"diagnostics,omitempty"`
+}
+
+var (
+	stepIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	refRe    = regexp.MustCompile(`\$\{([A-Za-z0-9_-]+)((?:\.[^.}]+)*)\}`)
+)
+
+// WorkflowExecutor enchaîne les étapes via le pipeline des tâches (capability, schéma,
+// verrou VM, délai de chaque action) et publie un résultat agrégé. En cas d'échec,
+// les compensations des étapes réussies sont exécutées en ordre inverse, y compris
+// après un task.cancel.
+type WorkflowExecutor struct{}
+
+func (WorkflowExecutor) Execute(ctx context.Context, req ExecRequest) ([]byte, error) {
+	if req.Diag != nil {
+		req.Diag.Runner = "workflow"
+	}
+	steps, errs := parseWorkflow(req.Data)
+	if len(errs) > 0 {
+		err := amqp.CodedError(amqp.CodeInvalidInput, fmt.Errorf("%w: %s", ErrInvalidInput, strings.Join(errs, "; ")))
+		b, _ := json.Marshal(map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeInvalidInput, "errors": errs})
+		return b, err
+	}
+
+	t := req.Task
+	// VM déjà verrouillées pour tout le workflow (réservées à l'arrivée, voir ReserveTaskLock)
+	held := map[string]bool{}
+	for _, k := range workflowLockKeys(req.Data) {
+		held[k] = true
+	}
+	outputs := map[string]any{}
+	records := make([]stepRecord, len(steps))
+	failed := -1
+	var stepErr error
+	for i, st := range steps {
+		records[i] = stepRecord{ID: st.ID, Action: st.Action, Status: "skipped"}
+		if failed >= 0 {
+			continue
+		}
+		data, err := resolveRefs(st.Data, outputs)
+		if err != nil {
+			stepErr = amqp.CodedError(amqp.CodeInvalidInput, err)
+			records[i] = recordOf(st.ID, st.Action, nil, nil, stepErr, 0)
+			failed = i
+			continue
+		}
+		log.Printf("[TASK] workflow taskId=%s step %d/%d id=%s action=%s", t.TaskID, i+1, len(steps), st.ID, st.Action)
+		report(req.OnProgress, powershell.Progress{
+			Percent: float64(i*100) / float64(len(steps)),
+			Step:    st.ID,
+			Message: fmt.Sprintf("step %d/%d: %s", i+1, len(steps), st.Action),
+		})
+		start := time.Now()
+		res, diag, err := runStep(ctx, t, st.Action, data, held, stepProgress(req.OnProgress, st.ID, i, len(steps)))
+		records[i] = recordOf(st.ID, st.Action, res, diag, err, time.Since(start))
+		if err != nil {
+			stepErr = err
+			failed = i
+			continue
+		}
+		outputs[st.ID] = res
+	}
+
+	out := map[string]any{"ok": failed < 0, "steps": records}
+	if failed < 0 {
+		if req.Diag != nil {
+			req.Diag.ExitCode = 0
+		}
+		return json.Marshal(out)
+	}
+	if req.Diag != nil {
+		req.Diag.ExitCode = 1
+	}
+
+	// Compensation des étapes réussies, de la plus récente à la plus ancienne
+	// (contexte détaché: s'applique aussi après annulation ou échéance)
+	comps := []stepRecord{}
+	compFailed := false
+	undo := context.WithoutCancel(ctx)
+	for i := failed - 1; i >= 0; i-- {
+		c := steps[i].Compensate
+		if c == nil {
+			continue
+		}
+		report(req.OnProgress, powershell.Progress{Step: "compensate/" + steps[i].ID, Message: "compensating " + steps[i].ID + ": " + c.Action})
+		start := time.Now()
+		data, err := resolveRefs(c.Data, outputs)
+		var res any
+		var diag *powershell.Diagnostics
+		if err == nil {
+			res, diag, err = runStep(undo, t, c.Action, data, held, nil)
+		} else {
+			err = amqp.CodedError(amqp.CodeInvalidInput, err)
+		}
+		if err != nil {
+			compFailed = true
+			log.Printf("[TASK] workflow taskId=%s compensation of %s (%s) failed: %v", t.TaskID, steps[i].ID, c.Action, err)
+		}
+		comps = append(comps, recordOf(steps[i].ID, c.Action, res, diag, err, time.Since(start)))
+	}
+
+	code, retryable := amqp.Classify(stepErr)
+	out["failedStep"] = steps[failed].ID
+	out["error"] = fmt.Sprintf("step %s (%s) failed: %s", steps[failed].ID, steps[failed].Action, records[failed].Error)
+	out["code"] = code
+	out["compensations"] = comps
+	if compFailed {
+		out["compensationFailed"] = true // état partiel: pas de rejeu automatique
+	}
+	b, _ := json.Marshal(out)
+	return b, &amqp.TaskError{
+		Code:      code,
+		Retryable: retryable && !compFailed,
+		Err:       fmt.Errorf("workflow step %s (%s) failed: %w", steps[failed].ID, steps[failed].Action, stepErr),
+	}
+}
+
+// runStep exécute une action du workflow comme une tâche à part entière (même taskId).
+// Seule une VM hors de held (clé issue d'une étape précédente) est verrouillée ici; l'attente
+// est bornée par ctx et par le délai de l'action.
+func runStep(ctx context.Context, t amqp.Task, action string, data map[string]any, held map[string]bool, onProgress func(powershell.Progress)) (any, *powershell.Diagnostics, error) {
+	st := amqp.Task{TaskID: t.TaskID, TenantID: t.TenantID, Action: action, Data: data, CorrelationID: t.CorrelationID, Attempt: t.Attempt}
+	m, out, err := admit(st)
+	if err != nil {
+		return out, nil, err
+	}
+	if key := vmLockKey(data); key != "" && !held[key] && !readOnly(action) {
+		wctx, cancel := context.WithTimeout(ctx, taskTimeout(st, m))
+		tk := vmLocks.reserve(key)
+		err := tk.WaitContext(wctx)
+		cancel()
+		if err != nil {
+			if cancelledBy(ctx) {
+				out, cErr := cancelledResult(ctx, st, nil)
+				return out, nil, cErr
+			}
+			err = fmt.Errorf("%w: %s waited too long for %s", amqp.ErrTaskTimeout, action, key)
+			return map[string]any{"ok": false, "error": err.Error(), "code": amqp.CodeTimeout}, nil, err
+		}
+		defer tk.Release()
+	}
+	diag := powershell.Diagnostics{ExitCode: -1}
+	res, err := runAction(ctx, st, m, &diag, onProgress)
+	return res, &diag, err
+}
+
+func recordOf(id, action string, res any, diag *powershell.Diagnostics, err error, took time.Duration) stepRecord {
+	r := stepRecord{ID: id, Action: action, Status: "succeeded", Result: res, DurationMs: took.Milliseconds(), Diagnostics: diag}
+	if err == nil {
+		return r
+	}
+	r.Code, _ = amqp.Classify(err)
+	r.Error = err.Error()
+	if m, ok := res.(map[string]any); ok {
+		if s, ok := m["error"].(string); ok && s != "" {
+			r.Error = s
+		}
+	}
+	switch {
+	case errors.Is(err, amqp.ErrTaskTimeout):
+		r.Status = "timeout"
+	case errors.Is(err, amqp.ErrTaskCancelled):
+		r.Status = "cancelled"
+	default:
+		r.Status = "failed"
+	}
+	return r
+}
+
+func report(onProgress func(powershell.Progress), p powershell.Progress) {
+	if onProgress != nil {
+		onProgress(p)
+	}
+}
+
+// stepProgress ramène la progression d'une étape à celle du workflow.
+func stepProgress(onProgress func(powershell.Progress), id string, i, n int) func(powershell.Progress) {
+	if onProgress == nil {
+		return nil
+	}
+	return func(p powershell.Progress) {
+		pct := min(max(p.Percent, 0), 100)
+		step := id
+		if p.Step != "" {
+			step += "/" + p.Step
+		}
+		onProgress(powershell.Progress{Percent: (float64(i)*100 + pct) / float64(n), Step: step, Message: p.Message})
+	}
+}
+
+// parseWorkflow lit data.steps et renvoie toutes les erreurs de structure d'un coup.
+func parseWorkflow(data map[string]any) ([]workflowStep, []string) {
+	var steps []workflowStep
+	raw, _ := json.Marshal(data["steps"])
+	if err := json.Unmarshal(raw, &steps); err != nil {
+		return nil, []string{"steps: " + err.Error()}
+	}
+	if len(steps) == 0 {
+		return nil, []string{"steps: at least one step is required"}
+	}
+	var errs []string
+	seen := map[string]bool{}
+	checkAction := func(where, action string) {
+		switch action {
+		case "":
+			errs = append(errs, where+": action is required")
+		case WorkflowAction, amqp.CancelAction:
+			errs = append(errs, fmt.Sprintf("%s: action %s is not allowed in a workflow", where, action))
+		}
+	}
+	checkRefs := func(where string, data map[string]any, self string) {
+		for _, id := range refIDs(data) {
+			if !seen[id] && id != self {
+				errs = append(errs, fmt.Sprintf("%s: ${%s...} does not refer to an earlier step", where, id))
+			}
+		}
+	}
+	for i, st := range steps {
+		where := fmt.Sprintf("steps[%d]", i)
+		switch {
+		case !stepIDRe.MatchString(st.ID):
+			errs = append(errs, where+": id must match [A-Za-z0-9_-]+")
+		case seen[st.ID]:
+			errs = append(errs, fmt.Sprintf("%s: duplicate id %q", where, st.ID))
+		}
+		checkAction(where, st.Action)
+		checkRefs(where+".data", st.Data, "")
+		if st.Compensate != nil {
+			checkAction(where+".compensate", st.Compensate.Action)
+			checkRefs(where+".compensate.data", st.Compensate.Data, st.ID)
+		}
+		seen[st.ID] = true
+	}
+	return steps, errs
+}
+
+// workflowTimeout: somme des délais des étapes et des compensations.
+func workflowTimeout(data map[string]any) time.Duration {
+	steps, errs := parseWorkflow(data)
+	if len(errs) > 0 {
+		return ActionTimeout(WorkflowAction)
+	}
+	var total time.Duration
+	for _, st := range steps {
+		total += ActionTimeout(st.Action)
+		if st.Compensate != nil {
+			total += ActionTimeout(st.Compensate.Action)
+		}
+	}
+	return total
+}
+
+// refIDs: étapes référencées par les chaînes "${id...}" de v.
+func refIDs(v any) []string {
+	var out []string
+	walkStrings(v, func(s string) {
+		for _, m := range refRe.FindAllStringSubmatch(s, -1) {
+			out = append(out, m[1])
+		}
+	})
+	return out
+}
+
+func walkStrings(v any, fn func(string)) {
+	switch x := v.(type) {
+	case string:
+		fn(x)
+	case map[string]any:
+		for _, val := range x {
+			walkStrings(val, fn)
+		}
+	case []any:
+		for _, val := range x {
+			walkStrings(val, fn)
+		}
+	}
+}
+
+// resolveRefs remplace les références "${id.chemin}" par les sorties des étapes.
+// Une chaîne réduite à une seule référence prend la valeur telle quelle (objet, nombre, ...).
+func resolveRefs(data map[string]any, outputs map[string]any) (map[string]any, error) {
+	v, err := resolveValue(data, outputs)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := v.(map[string]any)
+	return m, nil
+}
+
+func resolveValue(v any, outputs map[string]any) (any, error) {
+	switch x := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, val := range x {
+			r, err := resolveValue(val, outputs)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(x))
+		for i, val := range x {
+			r, err := resolveValue(val, outputs)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case string:
+		if m := refRe.FindStringSubmatch(x); m != nil && m[0] == x {
+			return lookupRef(m[1], m[2], outputs)
+		}
+		var rErr error
+		s := refRe.ReplaceAllStringFunc(x, func(ref string) string {
+			m := refRe.FindStringSubmatch(ref)
+			val, err := lookupRef(m[1], m[2], outputs)
+			if err != nil {
+				rErr = err
+				return ref
+			}
+			if str, ok := val.(string); ok {
+				return str
+			}
+			b, _ := json.Marshal(val)
+			return string(b)
+		})
+		return s, rErr
+	default:
+		return v, nil
+	}
+}
+
+// lookupRef suit path (".a.b.0") dans la sortie de l'étape id.
+func lookupRef(id, path string, outputs map[string]any) (any, error) {
+	cur, ok := outputs[id]
+	if !ok {
+		return nil, fmt.Errorf("${%s%s}: step %s has no output", id, path, id)
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		if seg == "" {
+			continue
+		}
+		switch x := cur.(type) {
+		case map[string]any:
+			cur, ok = x[seg]
+		case []any:
+			n, err := strconv.Atoi(seg)
+			ok = err == nil && n >= 0 && n < len(x)
+			if ok {
+				cur = x[n]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("${%s%s}: %q not found in the output of step %s", id, path, seg, id)
+		}
+	}
+	return cur, nil
+}
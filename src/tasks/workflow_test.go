@@ -0,0 +1,129 @@
+package tasks
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveRefs(t *testing.T) {
+	outputs := map[string]any{
+		"create": map[string]any{
+			"ok":     true,
+			"result": map[string]any{"guid": "G-1", "disks": []any{"a.vhdx", "b.vhdx"}, "cpu": float64(4)},
+		},
+	}
+	cases := []struct {
+		name    string
+		data    map[string]any
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name: "whole value keeps its type",
+			data: map[string]any{"guid": "${create.result.guid}", "cpu": "${create.result.cpu}", "r": "${create.result}"},
+			want: map[string]any{"guid": "G-1", "cpu": float64(4), "r": outputs["create"].(map[string]any)["result"]},
+		},
+		{
+			name: "array index",
+			data: map[string]any{"disk": "${create.result.disks.1}"},
+			want: map[string]any{"disk": "b.vhdx"},
+		},
+		{
+			name: "interpolated",
+			data: map[string]any{"msg": "vm ${create.result.guid} has ${create.result.cpu} cpu"},
+			want: map[string]any{"msg": "vm G-1 has 4 cpu"},
+		},
+		{
+			name: "nested",
+			data: map[string]any{"target": map[string]any{"refId": "${create.result.guid}"}, "list": []any{"${create.ok}", 1}},
+			want: map[string]any{"target": map[string]any{"refId": "G-1"}, "list": []any{true, 1}},
+		},
+		{
+			name: "no reference",
+			data: map[string]any{"name": "web", "n": 2, "cost": "$5 {x}"},
+			want: map[string]any{"name": "web", "n": 2, "cost": "$5 {x}"},
+		},
+		{
+			name:    "unknown step",
+			data:    map[string]any{"guid": "${other.result.guid}"},
+			wantErr: "step other has no output",
+		},
+		{
+			name:    "missing key",
+			data:    map[string]any{"guid": "${create.result.nope}"},
+			wantErr: `"nope" not found in the output of step create`,
+		},
+		{
+			name:    "index out of range",
+			data:    map[string]any{"disk": "vhd: ${create.result.disks.5}"},
+			wantErr: `"5" not found`,
+		},
+		{
+			name:    "path through a scalar",
+			data:    map[string]any{"x": "${create.result.guid.len}"},
+			wantErr: `"len" not found`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := resolveRefs(c.data, outputs)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("err = %v, want %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseWorkflow(t *testing.T) {
+	step := func(id, action string, data map[string]any) map[string]any {
+		return map[string]any{"id": id, "action": action, "data": data}
+	}
+	cases := []struct {
+		name  string
+		steps any
+		want  []string // fragments attendus, un par erreur
+	}{
+		{"valid", []any{
+			step("create", "vm.create", map[string]any{"name": "web"}),
+			step("start", "vm.power", map[string]any{"guid": "${create.result.guid}"}),
+		}, nil},
+		{"missing", nil, []string{"at least one step"}},
+		{"not a list", "x", []string{"steps: "}},
+		{"bad id and action", []any{step("a b", "", nil)}, []string{"id must match", "action is required"}},
+		{"duplicate id", []any{step("a", "echo", nil), step("a", "echo", nil)}, []string{`duplicate id "a"`}},
+		{"nested workflow", []any{step("a", WorkflowAction, nil)}, []string{"not allowed in a workflow"}},
+		{"forward reference", []any{
+			step("a", "echo", map[string]any{"x": "${b.result}"}),
+			step("b", "echo", nil),
+		}, []string{"${b...} does not refer to an earlier step"}},
+		{"self reference", []any{step("a", "echo", map[string]any{"x": "${a.result}"})},
+			[]string{"${a...} does not refer to an earlier step"}},
+		{"compensation sees its step", []any{map[string]any{
+			"id": "a", "action": "vm.create",
+			"compensate": map[string]any{"action": "vm.delete", "data": map[string]any{"guid": "${a.result.guid}"}},
+		}}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, errs := parseWorkflow(map[string]any{"steps": c.steps})
+			if len(errs) != len(c.want) {
+				t.Fatalf("errs = %q, want %d error(s)", errs, len(c.want))
+			}
+			for i, frag := range c.want {
+				if !strings.Contains(errs[i], frag) {
+					t.Errorf("errs[%d] = %q, want %q", i, errs[i], frag)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Package taskstore persiste l'état des tâches (taskId -> statut/résultat publié)
+// pour rendre leur exécution idempotente face aux redeliveries RabbitMQ.
+package taskstore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusRunning Status = "running" // exécution en cours (par le process "Boot")
+	StatusDone    Status = "done"    // essai terminé (Result = enveloppe publiée, vide si un rejeu a été planifié)
+)
+
+// Decision indique au consumer quoi faire d'une tâche reçue.
+type Decision int
+
+const (
+	Run         Decision = iota // jamais vue (ou nouvel essai): exécuter
+	Replay                      // déjà terminée: republier Result (si non vide) sans exécuter
+	InProgress                  // déjà en cours dans ce process: ne pas démarrer une 2e fois
+	Interrupted                 // démarrée par un process précédent qui n'a pas fini: ne pas relancer à l'aveugle
+)
+
+type Record struct {
+	TaskID    string          `json:"taskId"`
+	Attempt   int             `json:"attempt"`
+	Status    Status          `json:"status"`
+	Ok        bool            `json:"ok"`
+	Boot      string          `json:"boot"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+type Store struct {
+	dir       string
+	boot      string
+	retention time.Duration
+	mu        sync.Mutex
+}
+
+// Open prépare le store sous dir (créé si besoin) et purge les entrées
+// plus anciennes que retention (puis toutes les heures).
+func Open(dir string, retention time.Duration) (*Store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("taskstore: empty dir")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("taskstore: mkdir %s: %w", dir, err)
+	}
+	boot := make([]byte, 8)
+	_, _ = rand.Read(boot)
+	s := &Store{dir: dir, boot: hex.EncodeToString(boot), retention: retention}
+	s.prune()
+	go func() {
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for range t.C {
+			s.prune()
+		}
+	}()
+	return s, nil
+}
+
+// Begin décide du sort d'une tâche reçue et, si elle doit s'exécuter, la marque
+// "running" de façon atomique. Renvoie aussi l'enregistrement existant (Replay).
+func (s *Store) Begin(taskID string, attempt int) (Decision, *Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.read(taskID)
+	if err == nil && rec != nil {
+		switch {
+		case rec.Status == StatusDone && (rec.Ok || attempt <= rec.Attempt):
+			return Replay, rec
+		case rec.Status == StatusRunning && rec.Boot == s.boot:
+			return InProgress, rec
+		case rec.Status == StatusRunning && attempt <= rec.Attempt:
+			// Marqué "done" (sans résultat) pour ne pas rejouer l'interruption à chaque redelivery
+			_ = s.writeLocked(Record{TaskID: taskID, Attempt: rec.Attempt, Status: StatusDone, Boot: s.boot})
+			return Interrupted, rec
+		}
+	}
+
+	if err := s.writeLocked(Record{TaskID: taskID, Attempt: attempt, Status: StatusRunning, Boot: s.boot}); err != nil {
+		log.Printf("[STATE] task %s: %v (dedup disabled for this task)", taskID, err)
+	}
+	return Run, nil
+}
+
+// Finish enregistre la fin d'un essai. envelope = résultat publié (nil si un rejeu est planifié).
+func (s *Store) Finish(taskID string, attempt int, ok bool, envelope []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := Record{TaskID: taskID, Attempt: attempt, Status: StatusDone, Ok: ok, Boot: s.boot}
+	if len(envelope) > 0 {
+		rec.Result = json.RawMessage(envelope)
+	}
+	if err := s.writeLocked(rec); err != nil {
+		log.Printf("[STATE] task %s: %v", taskID, err)
+	}
+}
+
+func (s *Store) path(taskID string) string {
+	h := sha256.Sum256([]byte(taskID))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:16])+".json")
+}
+
+func (s *Store) read(taskID string) (*Record, error) {
+	b, err := os.ReadFile(s.path(taskID))
+	if err != nil {
+		return nil, err
+	}
+	var rec Record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	if rec.TaskID != taskID {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+// writeLocked écrit l'enregistrement de façon atomique (fichier temporaire + rename).
+func (s *Store) writeLocked(rec Record) error {
+	rec.UpdatedAt = time.Now().UTC()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".task-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer func() { _ = os.Remove(tmpPath) }()
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path(rec.TaskID))
+}
+
+func (s *Store) prune() {
+	if s.retention <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-s.retention)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			_ = os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package taskstore
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBegin(t *testing.T) {
+	cases := []struct {
+		name    string
+		setup   func(prev, cur *Store) // prev: process précédent (même dossier)
+		attempt int
+		want    Decision
+		result  string // Result attendu pour Replay
+	}{
+		{"new task", func(_, _ *Store) {}, 1, Run, ""},
+		{"running in this process", func(_, cur *Store) { cur.Begin("t", 1) }, 1, InProgress, ""},
+		{"succeeded", func(_, cur *Store) {
+			cur.Begin("t", 1)
+			cur.Finish("t", 1, true, []byte(`{"ok":true}`))
+		}, 2, Replay, `{"ok":true}`},
+		{"failed, same attempt", func(_, cur *Store) {
+			cur.Begin("t", 2)
+			cur.Finish("t", 2, false, []byte(`{"ok":false}`))
+		}, 2, Replay, `{"ok":false}`},
+		{"failed, new attempt", func(_, cur *Store) {
+			cur.Begin("t", 1)
+			cur.Finish("t", 1, false, nil)
+		}, 2, Run, ""},
+		{"interrupted by a restart", func(prev, _ *Store) { prev.Begin("t", 1) }, 1, Interrupted, ""},
+		{"interrupted, new attempt", func(prev, _ *Store) { prev.Begin("t", 1) }, 2, Run, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			prev := open(t, dir)
+			cur := open(t, dir)
+			c.setup(prev, cur)
+			got, rec := cur.Begin("t", c.attempt)
+			if got != c.want {
+				t.Fatalf("Begin = %d, want %d", got, c.want)
+			}
+			if c.want == Replay && string(rec.Result) != c.result {
+				t.Errorf("Result = %s, want %s", rec.Result, c.result)
+			}
+			if c.want == Run {
+				// La tâche est désormais marquée en cours
+				if again, _ := cur.Begin("t", c.attempt); again != InProgress {
+					t.Errorf("second Begin = %d, want InProgress", again)
+				}
+			}
+		})
+	}
+}
+
+// Une interruption n'est signalée qu'une fois: les redeliveries suivantes sont rejouées (sans résultat).
+func TestBeginInterruptedOnce(t *testing.T) {
+	dir := t.TempDir()
+	open(t, dir).Begin("t", 1)
+	cur := open(t, dir)
+	if d, _ := cur.Begin("t", 1); d != Interrupted {
+		t.Fatalf("first Begin = %d, want Interrupted", d)
+	}
+	d, rec := cur.Begin("t", 1)
+	if d != Replay || len(rec.Result) != 0 {
+		t.Fatalf("second Begin = %d (result %s), want Replay without result", d, rec.Result)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir)
+	s.Begin("old", 1)
+	s.Begin("new", 1)
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(s.path("old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	s.retention = time.Hour
+	s.prune()
+	if _, err := os.Stat(s.path("old")); !os.IsNotExist(err) {
+		t.Errorf("old record not pruned: %v", err)
+	}
+	if _, err := os.Stat(s.path("new")); err != nil {
+		t.Errorf("new record pruned: %v", err)
+	}
+}
+
+func open(t *testing.T, dir string) *Store {
+	t.Helper()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
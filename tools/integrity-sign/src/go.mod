@@ -0,0 +1,3 @@
+module openhvx-integrity-sign
+
+go 1.25.0
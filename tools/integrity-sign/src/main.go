@@ -0,0 +1,122 @@
+// Sign the SHA-256 manifest of src/powershell (integrity.json + integrity.json.sig)
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	manifestName = "integrity.json"
+	sigName      = "integrity.json.sig"
+)
+
+type manifest struct {
+	V         int               `json:"v"`
+	CreatedAt string            `json:"createdAt"`
+	Files     map[string]string `json:"files"`
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Fichiers couverts: host.ps1, actions/**, bin/** (les sources Go du dossier sont ignorées)
+func collect(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if !(rel == "host.ps1" || strings.HasPrefix(rel, "actions/") || strings.HasPrefix(rel, "bin/")) {
+			return nil
+		}
+		sum, err := sha256File(p)
+		if err != nil {
+			return err
+		}
+		files[rel] = sum
+		return nil
+	})
+	return files, err
+}
+
+func genKey(path string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(priv)+"\n"), 0o600); err != nil {
+		log.Fatal(err)
+	}
+	// Clé publique à mettre dans "integrityPublicKeys" (config de l'agent)
+	fmt.Println(base64.StdEncoding.EncodeToString(pub))
+}
+
+func main() {
+	dir := flag.String("dir", "src/powershell", "powershell directory to sign")
+	keyPath := flag.String("key", "", "Ed25519 private key file (base64)")
+	gen := flag.Bool("genkey", false, "generate a new key pair into -key and print the public key")
+	flag.Parse()
+
+	if *keyPath == "" {
+		log.Fatal("usage: integrity-sign -key <signing.key> [-dir src/powershell] | -genkey -key <signing.key>")
+	}
+	if *gen {
+		genKey(*keyPath)
+		return
+	}
+
+	raw, err := os.ReadFile(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	priv, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		log.Fatal("invalid Ed25519 private key")
+	}
+
+	files, err := collect(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	body, _ := json.MarshalIndent(manifest{
+		V:         1,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Files:     files,
+	}, "", "  ")
+	sig := ed25519.Sign(ed25519.PrivateKey(priv), body)
+
+	if err := os.WriteFile(filepath.Join(*dir, manifestName), body, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, sigName), []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("signed %d files into %s", len(files), filepath.Join(*dir, manifestName))
+}